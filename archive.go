@@ -0,0 +1,71 @@
+package cloudconfig
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ArchiveComment is the first line of a cloud-config-archive document.
+const ArchiveComment = "#cloud-config-archive"
+
+// TypeArchive is the content type of a cloud-config-archive document.
+const TypeArchive = "text/cloud-config-archive"
+
+// ArchivePart is an entry of a cloud-config-archive document.
+// An entry may also be a string, which is used as its Content.
+type ArchivePart struct {
+	// Type is the content type of the part, such as text/cloud-config.
+	// If it is empty, it is determined from the first line of the content,
+	// and defaults to text/cloud-config.
+	Type      string `yaml:"type,omitempty"`
+	Content   string `yaml:"content"`
+	Filename  string `yaml:"filename,omitempty"`
+	MergeType string `yaml:"merge_type,omitempty"`
+}
+
+func (t *ArchivePart) UnmarshalYAML(unmarshal func(any) error) error {
+	var content string
+	err := unmarshal(&content)
+	if err == nil {
+		*t = ArchivePart{Content: content}
+		return nil
+	}
+	type part ArchivePart
+	return unmarshal((*part)(t))
+}
+
+// UnmarshalArchive parses a cloud-config-archive document.
+func UnmarshalArchive(data []byte) ([]*ArchivePart, error) {
+	if !FirstLineIs(data, ArchiveComment) {
+		return nil, fmt.Errorf("does not start with %s", ArchiveComment)
+	}
+	var parts []*ArchivePart
+	err := yaml.Unmarshal(data, &parts)
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// archive dispatches each part of a cloud-config-archive document by type.
+func (t *userDataParser) archive(data []byte) error {
+	parts, err := UnmarshalArchive(data)
+	if err != nil {
+		return err
+	}
+	for i, part := range parts {
+		mediaType := part.Type
+		if mediaType == "" {
+			mediaType = sniffType([]byte(part.Content))
+			if mediaType == TypePlain {
+				mediaType = TypeCloudConfig
+			}
+		}
+		err := t.handlePart(mediaType, part.Filename, part.MergeType, []byte(part.Content))
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package cloudconfig
 
 import (
+	"context"
 	"io"
 	"io/fs"
 )
 
+// BaseConfigurer is the interface that implementations provide to apply a config to a target.
+// cloudconfigtest.RunConformance checks that an implementation follows it.
 type BaseConfigurer interface {
 	SetLogWriter(io.Writer)
 
@@ -43,3 +46,28 @@ type BaseApplySudo interface {
 	// There is no mechanism to specify no privileges
 	ApplySudo(username string, values []string) error
 }
+
+// Optional interface that is notified when Configurer starts a section,
+// such as packages or runcmd.  See the Section constants.
+type BaseSection interface {
+	BeginSection(name string)
+}
+
+// Optional interface to run commands and scripts with a context,
+// so that they can be cancelled, or stopped after a timeout.
+// If not implemented, Configurer checks the context only between operations.
+type BaseContext interface {
+	// RunCommandContext is like RunCommand, but stops the command when ctx is done.
+	RunCommandContext(ctx context.Context, args ...string) error
+
+	// RunScriptContext is like RunScript, but stops the script when ctx is done.
+	RunScriptContext(ctx context.Context, input string) error
+}
+
+// Optional interface to run commands with options, such as environment variables or another user.
+// It is used for runcmd entries in the map form.
+// If not implemented, Configurer runs such entries with Command.ShellScript.
+type BaseCommandOptions interface {
+	// RunCommandOptions runs program args[0], with args args, and the given options.
+	RunCommandOptions(ctx context.Context, options *CommandOptions, args ...string) error
+}
@@ -0,0 +1,161 @@
+// Package chroot provides a BaseConfigurer that applies a config to a root filesystem,
+// such as a mounted disk image, instead of the running system.
+package chroot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"melato.org/cloudconfig"
+)
+
+// maxSymlinks is the maximum number of symbolic links followed when resolving a path.
+const maxSymlinks = 255
+
+// BaseConfigurer applies a config to the root filesystem in Root.
+// Files are resolved under Root, following symbolic links as if Root were "/",
+// so that a link in the image cannot point outside it.
+// Commands and scripts are run with chroot, which requires root privileges.
+type BaseConfigurer struct {
+	Root string
+	Log  io.Writer
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+// Path returns the host path of a path in the root filesystem.
+// Symbolic links are resolved relative to Root, and ".." does not go above Root.
+// Components that do not exist are appended as they are.
+func (t *BaseConfigurer) Path(file string) (string, error) {
+	var resolved string
+	remaining := file
+	links := 0
+	for remaining != "" {
+		var name string
+		name, remaining, _ = strings.Cut(remaining, "/")
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir("/" + resolved)
+			resolved = strings.TrimPrefix(resolved, "/")
+			continue
+		}
+		next := path.Join(resolved, name)
+		hostPath := filepath.Join(t.Root, filepath.FromSlash(next))
+		st, err := os.Lstat(hostPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+		if st.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("%s: too many symbolic links", file)
+		}
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(target, "/") {
+			resolved = ""
+		}
+		remaining = target + "/" + remaining
+	}
+	return filepath.Join(t.Root, filepath.FromSlash(resolved)), nil
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	return t.RunCommandContext(context.Background(), args...)
+}
+
+func (t *BaseConfigurer) RunCommandContext(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	cmd := exec.CommandContext(ctx, "chroot", append([]string{t.Root}, args...)...)
+	cmd.Stdout = t.Log
+	cmd.Stderr = t.Log
+	return cmd.Run()
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	return t.RunScriptContext(context.Background(), script)
+}
+
+func (t *BaseConfigurer) RunScriptContext(ctx context.Context, script string) error {
+	cmd := exec.CommandContext(ctx, "chroot", t.Root, "/bin/sh")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout = t.Log
+	cmd.Stderr = t.Log
+	return cmd.Run()
+}
+
+func (t *BaseConfigurer) WriteFile(file string, data []byte, perm fs.FileMode) error {
+	hostPath, err := t.Path(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(hostPath, data, perm)
+}
+
+func (t *BaseConfigurer) AppendFile(file string, data []byte, perm fs.FileMode) error {
+	hostPath, err := t.Path(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(hostPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (t *BaseConfigurer) FileExists(file string) (bool, error) {
+	hostPath, err := t.Path(file)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(hostPath)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// UserHomeDir looks up a user's home directory in the /etc/passwd of the root filesystem.
+func (t *BaseConfigurer) UserHomeDir(username string) (string, error) {
+	passwd, err := t.Path("/etc/passwd")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(passwd)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return cloudconfig.PasswdHomeDir(f, username)
+}
@@ -0,0 +1,93 @@
+package chroot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"melato.org/cloudconfig"
+)
+
+func TestInterfaces(t *testing.T) {
+	base := &BaseConfigurer{}
+	var _ cloudconfig.BaseConfigurer = base
+	var _ cloudconfig.BaseUserHomeDir = base
+	var _ cloudconfig.BaseContext = base
+}
+
+func TestPath(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.MkdirAll(filepath.Join(root, "usr", "lib"), 0755)
+	os.Symlink("/usr/lib", filepath.Join(root, "lib"))
+	os.Symlink("../../../..", filepath.Join(root, "etc", "up"))
+	os.Symlink("/etc", filepath.Join(root, "etc", "loop"))
+	base := &BaseConfigurer{Root: root}
+	cases := []struct{ path, expected string }{
+		{"/etc/a", "/etc/a"},
+		{"/lib/x", "/usr/lib/x"},
+		{"/../../etc/a", "/etc/a"},
+		{"/etc/up/etc/a", "/etc/a"},
+		{"/etc/loop/loop/a", "/etc/a"},
+		{"/new/dir/a", "/new/dir/a"},
+	}
+	for _, c := range cases {
+		p, err := base.Path(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != filepath.Join(root, c.expected) {
+			t.Errorf("%s: %s, expected %s", c.path, p, c.expected)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.Symlink(outside, filepath.Join(root, "etc", "x"))
+	base := &BaseConfigurer{Root: root}
+	err := base.WriteFile("/etc/x/a", []byte("a"), 0644)
+	if err == nil {
+		t.Fatalf("should not write to a missing directory")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a")); err == nil {
+		t.Fatalf("wrote outside root")
+	}
+	err = base.AppendFile("/etc/a", []byte("a"), 0644)
+	if err == nil {
+		err = base.AppendFile("/etc/a", []byte("b"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "etc", "a"))
+	if err != nil || string(data) != "ab" {
+		t.Fatalf("%v %s", err, string(data))
+	}
+	exists, err := base.FileExists("/etc/a")
+	if err != nil || !exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+	exists, err = base.FileExists("/etc/b")
+	if err != nil || exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+}
+
+func TestUserHomeDir(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(
+		"root:x:0:0:root:/root:/bin/sh\nbuild:x:1000:1000::/srv/build:/bin/sh\n"), 0644)
+	base := &BaseConfigurer{Root: root}
+	home, err := base.UserHomeDir("build")
+	if err != nil || home != "/srv/build" {
+		t.Fatalf("%s %v", home, err)
+	}
+	_, err = base.UserHomeDir("nobody")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -2,51 +2,263 @@ package cli
 
 import (
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/chroot"
+	"melato.org/cloudconfig/dockerfile"
+	"melato.org/cloudconfig/execprefix"
+	"melato.org/cloudconfig/extract"
+	"melato.org/cloudconfig/ignition"
 	"melato.org/cloudconfig/local"
 	"melato.org/cloudconfig/ostype"
+	"melato.org/cloudconfig/plan"
+	"melato.org/cloudconfig/shell"
+	"melato.org/cloudconfig/ssh"
 )
 
 type App struct {
 	OS string
-	os cloudconfig.OSType
+	// Root is the root directory of a filesystem to apply to, using chroot
+	Root string
+	// SSH is the host to apply to, with SSH: [user@]host[:port]
+	SSH string
+	// Identity is a private key file for SSH.  The SSH agent is also used.
+	Identity string
+	// KnownHosts is a known_hosts file for SSH.  The default is ~/.ssh/known_hosts
+	KnownHosts string
+	// Exec is a command prefix to run everything through, such as "podman exec -i mybox"
+	Exec string
+	// Escalate runs local commands and file writes with sudo -n or doas -n: auto, sudo, doas
+	Escalate string
+	// Timeout is the maximum duration of each package install, runcmd entry, and script, such as 10m
+	Timeout string
+	// Report is a file to write a JSON report of the apply steps to, or - for stdout
+	Report string
+	// OnError is what to do when a step fails: fail, section (finish the section), continue
+	OnError string
+	// Retry is the number of attempts of each package install, with backoff starting from 5s
+	Retry int
+	// RetryRuncmd also retries each runcmd entry, Retry times
+	RetryRuncmd bool
+	// WaitLock waits for the package manager lock before each package install
+	WaitLock bool
+	os       cloudconfig.OSType
+	escalate []string
+	timeout  time.Duration
+	onError  cloudconfig.ErrorPolicy
 }
 
-func (t *App) Configured() error {
-	switch t.OS {
+func osType(name string) (cloudconfig.OSType, error) {
+	switch name {
 	case "":
+		return nil, nil
 	case "alpine":
-		t.os = &ostype.Alpine{}
+		return &ostype.Alpine{}, nil
 	case "debian":
-		t.os = &ostype.Debian{}
+		return &ostype.Debian{}, nil
 	default:
-		return fmt.Errorf("unrecognized OS.  accepted values are alpine, debian")
+		return nil, fmt.Errorf("unrecognized OS.  accepted values are alpine, debian")
 	}
-	return nil
+}
+
+func (t *App) Configured() error {
+	targets := 0
+	for _, target := range []string{t.Root, t.SSH, t.Exec} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return fmt.Errorf("use only one of -root, -ssh, -exec")
+	}
+	if t.Escalate != "" && targets > 0 {
+		return fmt.Errorf("-escalate applies only to the local system")
+	}
+	var err error
+	t.escalate, err = local.EscalationPrefix(t.Escalate)
+	if err != nil {
+		return err
+	}
+	if t.Timeout != "" {
+		t.timeout, err = time.ParseDuration(t.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	if t.Retry < 0 {
+		return fmt.Errorf("invalid retry: %d", t.Retry)
+	}
+	if t.RetryRuncmd && t.Retry == 0 {
+		return fmt.Errorf("-retryruncmd requires -retry")
+	}
+	t.onError, err = cloudconfig.ParseErrorPolicy(t.OnError)
+	if err != nil {
+		return err
+	}
+	t.os, err = osType(t.OS)
+	return err
+}
+
+func newReader() *cloudconfig.UserDataReader {
+	return &cloudconfig.UserDataReader{Fetcher: &cloudconfig.HTTPFetcher{}}
+}
+
+// readConfig reads a user-data file in any supported format and returns its merged config.
+func readConfig(file string) (*cloudconfig.Config, error) {
+	u, err := newReader().ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return u.Config, nil
 }
 
 func (t *App) Apply(configFiles ...string) error {
-	base := &local.BaseConfigurer{}
+	var base cloudconfig.BaseConfigurer
+	if t.SSH != "" {
+		auth := &ssh.Auth{}
+		if t.Identity != "" {
+			auth.KeyFiles = []string{t.Identity}
+		}
+		if t.KnownHosts != "" {
+			auth.KnownHosts = []string{t.KnownHosts}
+		}
+		sshBase, err := auth.Dial(t.SSH)
+		if err != nil {
+			return err
+		}
+		defer sshBase.Client.Close()
+		base = sshBase
+	} else if t.Exec != "" {
+		base = execprefix.NewBaseConfigurer(strings.Fields(t.Exec)...)
+	} else if t.Root != "" {
+		base = &chroot.BaseConfigurer{Root: t.Root}
+	} else {
+		base = &local.BaseConfigurer{Escalate: t.escalate}
+	}
 	base.SetLogWriter(os.Stdout)
 	configurer := cloudconfig.NewConfigurer(base)
 	configurer.OS = t.os
 	configurer.Log = os.Stdout
+	configurer.CommandTimeout = t.timeout
+	configurer.PackageTimeout = t.timeout
+	configurer.OnError = t.onError
+	configurer.WaitPackageLock = t.WaitLock
+	retry := cloudconfig.RetryPolicy{Attempts: t.Retry, Delay: 5 * time.Second, MaxDelay: time.Minute}
+	configurer.PackageRetry = retry
+	if t.RetryRuncmd {
+		configurer.CommandRetry = retry
+	}
+	configurer.Report = &cloudconfig.Report{Output: os.Stdout}
+	err := applyFiles(configurer, configFiles)
+	if len(configurer.Report.Failed()) > 0 {
+		configurer.Report.WriteSummary(os.Stderr)
+	}
+	if t.Report != "" {
+		reportErr := writeReport(configurer.Report, t.Report)
+		if err == nil {
+			err = reportErr
+		}
+	}
+	return err
+}
+
+// writeReport writes a report in JSON to a file, or to stdout, if file is "-".
+func writeReport(report *cloudconfig.Report, file string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// OSCmd has the flags of commands that do not apply to a target, such as plan and export-sh.
+type OSCmd struct {
+	OS string
+	os cloudconfig.OSType
+}
+
+func (t *OSCmd) Configured() error {
+	var err error
+	t.os, err = osType(t.OS)
+	return err
+}
+
+// Plan prints the operations that apply would perform, without performing them.
+func (t *OSCmd) Plan(configFiles ...string) error {
+	base := &plan.BaseConfigurer{}
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = t.os
+	err := applyFiles(configurer, configFiles)
+	if err != nil {
+		return err
+	}
+	return base.Write(os.Stdout)
+}
+
+// ExportSh prints a shell script that applies the config files.
+func (t *OSCmd) ExportSh(configFiles ...string) error {
+	base := shell.NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = t.os
+	err := applyFiles(configurer, configFiles)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(base.Script.Bytes())
+	return err
+}
+
+// applyFiles reads the config files, or stdin, if the only file is "-", and applies them with configurer.
+func applyFiles(configurer *cloudconfig.Configurer, configFiles []string) error {
+	configurer.Fetcher = &cloudconfig.HTTPFetcher{}
 	if len(configFiles) == 1 && configFiles[0] == "-" {
 		return configurer.ApplyStdin()
-	} else {
-		return configurer.ApplyConfigFiles(configFiles...)
 	}
+	return configurer.ApplyConfigFiles(configFiles...)
 }
 
-func Print(file string) error {
-	config, err := cloudconfig.ReadFile(file)
+// PrintCmd prints a config in YAML or JSON format
+type PrintCmd struct {
+	Format string
+}
+
+func (t *PrintCmd) Init() error {
+	t.Format = "yaml"
+	return nil
+}
+
+func (t *PrintCmd) Configured() error {
+	switch t.Format {
+	case "", "yaml", "json":
+		return nil
+	default:
+		return fmt.Errorf("unrecognized format.  accepted values are yaml, json")
+	}
+}
+
+func (t *PrintCmd) marshal(config *cloudconfig.Config) ([]byte, error) {
+	if t.Format == "json" {
+		return cloudconfig.MarshalJSON(config)
+	}
+	return cloudconfig.Marshal(config)
+}
+
+func (t *PrintCmd) Print(file string) error {
+	config, err := readConfig(file)
 	if err != nil {
 		return err
 	}
-	data, err := cloudconfig.Marshal(config)
+	data, err := t.marshal(config)
 	if err != nil {
 		return err
 	}
@@ -54,21 +266,62 @@ func Print(file string) error {
 	return nil
 }
 
-func Parse(files []string) error {
+// ParseCmd validates cloud-config files
+type ParseCmd struct {
+	// Strict fails on warnings, such as unknown keys, as well as errors
+	Strict bool
+}
+
+// Parse validates cloud-config files and prints any diagnostics.
+// Files in other user-data formats are only parsed.
+// It returns an error if any file has errors, or warnings with -strict.
+func (t *ParseCmd) Parse(files ...string) error {
+	var failed bool
 	for _, file := range files {
-		_, err := cloudconfig.ReadFile(file)
+		ok, err := t.parseFile(file)
 		if err != nil {
-			fmt.Printf("%s ERROR\n", file)
 			return err
 		}
-		fmt.Printf("%s OK\n", file)
+		if ok {
+			fmt.Printf("%s OK\n", file)
+		} else {
+			fmt.Printf("%s ERROR\n", file)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("validation failed")
 	}
 	return nil
 }
 
+func (t *ParseCmd) parseFile(file string) (bool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	if !cloudconfig.HasComment(data) && !cloudconfig.IsJSON(data) {
+		_, err := newReader().Parse(data)
+		if err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			return false, nil
+		}
+		return true, nil
+	}
+	diagnostics := cloudconfig.Validate(data)
+	for _, d := range diagnostics {
+		d.File = file
+		fmt.Println(d.String())
+	}
+	if t.Strict && cloudconfig.HasWarnings(diagnostics) {
+		return false, nil
+	}
+	return !cloudconfig.HasErrors(diagnostics), nil
+}
+
 func Packages(files []string) error {
 	for _, file := range files {
-		c, err := cloudconfig.ReadFile(file)
+		c, err := readConfig(file)
 		if err != nil {
 			return fmt.Errorf("%s %e\n", file, err)
 		}
@@ -78,3 +331,193 @@ func Packages(files []string) error {
 	}
 	return nil
 }
+
+// Merge reads cloud-config files, merges them with cloud-init merge semantics,
+// and prints the merged config.
+func Merge(files []string) error {
+	configs := make([]*cloudconfig.Config, len(files))
+	for i, file := range files {
+		config, err := readConfig(file)
+		if err != nil {
+			return err
+		}
+		configs[i] = config
+	}
+	config, err := cloudconfig.Merge(configs...)
+	if err != nil {
+		return err
+	}
+	data, err := cloudconfig.Marshal(config)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(data)
+	return nil
+}
+
+// Schema prints the JSON Schema of the supported cloud-config subset.
+func Schema() error {
+	data, err := cloudconfig.MarshalJSONSchema()
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(data)
+	return nil
+}
+
+// LintCmd checks cloud-config files for semantic problems
+type LintCmd struct {
+	// Disable is a comma-separated list of rule IDs to disable
+	Disable string
+}
+
+func (t *LintCmd) Lint(files []string) error {
+	options := &cloudconfig.LintOptions{}
+	for _, id := range strings.Split(t.Disable, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			options.Disable = append(options.Disable, id)
+		}
+	}
+	var failed bool
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var diagnostics []cloudconfig.Diagnostic
+		if cloudconfig.HasComment(data) || cloudconfig.IsJSON(data) {
+			diagnostics, err = cloudconfig.Lint(data, options)
+		} else {
+			var u *cloudconfig.UserData
+			u, err = newReader().Parse(data)
+			if err == nil {
+				diagnostics = cloudconfig.LintConfig(u.Config, options)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, d := range diagnostics {
+			d.File = file
+			fmt.Println(d.String())
+		}
+		if cloudconfig.HasErrors(diagnostics) {
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("lint failed")
+	}
+	return nil
+}
+
+// LintRules prints the lint rules
+func LintRules() {
+	for _, rule := range cloudconfig.LintRules() {
+		fmt.Printf("%s\t%s\t%s\n", rule.ID, rule.Severity, rule.Description)
+	}
+}
+
+// DockerfileCmd exports a Dockerfile and its build context
+type DockerfileCmd struct {
+	OS   string
+	From string
+	// Dir is the output directory
+	Dir string
+	os  cloudconfig.OSType
+}
+
+func (t *DockerfileCmd) Configured() error {
+	if t.From == "" {
+		return fmt.Errorf("missing -from image")
+	}
+	if t.Dir == "" {
+		return fmt.Errorf("missing -dir")
+	}
+	var err error
+	t.os, err = osType(t.OS)
+	return err
+}
+
+func (t *DockerfileCmd) Export(configFiles ...string) error {
+	base := dockerfile.NewBaseConfigurer(t.From, t.os)
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = t.os
+	err := applyFiles(configurer, configFiles)
+	if err != nil {
+		return err
+	}
+	return base.Context().WriteDir(t.Dir)
+}
+
+// ExtractCmd writes the files of a config to a tar archive or a directory
+type ExtractCmd struct {
+	// O is the output tar file.  "-" is stdout
+	O string
+	// Dir is the output directory, instead of a tar file
+	Dir string
+	// Skip skips commands that are not file operations, instead of failing
+	Skip bool
+}
+
+func (t *ExtractCmd) Configured() error {
+	if (t.O == "") == (t.Dir == "") {
+		return fmt.Errorf("specify one of -o, -dir")
+	}
+	return nil
+}
+
+func (t *ExtractCmd) Extract(configFiles ...string) error {
+	base := &extract.BaseConfigurer{SkipCommands: t.Skip}
+	base.SetLogWriter(os.Stderr)
+	err := applyFiles(cloudconfig.NewConfigurer(base), configFiles)
+	if err != nil {
+		return err
+	}
+	if t.Dir != "" {
+		return base.WriteDir(t.Dir)
+	}
+	if t.O == "-" {
+		return base.WriteTar(os.Stdout)
+	}
+	f, err := os.Create(t.O)
+	if err != nil {
+		return err
+	}
+	err = base.WriteTar(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", t.O, err)
+	}
+	return f.Close()
+}
+
+// IgnitionCmd converts a cloud-config to an Ignition config
+type IgnitionCmd struct {
+	// Strict fails if any part of the config has no Ignition equivalent
+	Strict bool
+}
+
+func (t *IgnitionCmd) Convert(file string) error {
+	u, err := newReader().ReadFile(file)
+	if err != nil {
+		return err
+	}
+	config, unsupported, err := ignition.Convert(u)
+	if err != nil {
+		return err
+	}
+	for _, x := range unsupported {
+		fmt.Fprintf(os.Stderr, "unsupported: %s\n", x.String())
+	}
+	if t.Strict && len(unsupported) > 0 {
+		return fmt.Errorf("%s: %d unsupported items", file, len(unsupported))
+	}
+	data, err := ignition.Marshal(config)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(data)
+	return nil
+}
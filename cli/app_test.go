@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseStrict(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.yaml")
+	err := os.WriteFile(file, []byte(`#cloud-config
+write_file:
+- path: /etc/a
+users:
+- name: a
+  ssh_authorised_keys: [key]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() { os.Stdout = stdout }()
+	err = (&ParseCmd{}).Parse(file)
+	if err != nil {
+		t.Fatalf("unknown keys should be warnings: %v", err)
+	}
+	err = (&ParseCmd{Strict: true}).Parse(file)
+	if err == nil {
+		t.Fatalf("-strict should fail on unknown keys")
+	}
+}
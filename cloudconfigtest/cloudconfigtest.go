@@ -0,0 +1,285 @@
+// Package cloudconfigtest provides an in-memory BaseConfigurer, for testing configs and Configurer wrappers.
+package cloudconfigtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"melato.org/cloudconfig/plan"
+)
+
+// File is a file or directory in the virtual filesystem.
+type File struct {
+	Dir   bool
+	Data  []byte
+	Mode  fs.FileMode
+	Owner string
+}
+
+// Result is the scripted result of a command or script.
+type Result struct {
+	// Output is written to the log writer.
+	Output string
+	Err    error
+	// Times, if not 0, is the number of runs that have this result.  Later runs succeed, without output.
+	Times int
+}
+
+// BaseConfigurer is an in-memory BaseConfigurer.
+// It has a virtual filesystem, which starts with the root directory,
+// and records all operations, in the same form as the plan package.
+//
+// It interprets the file commands that Configurer runs: mkdir -p, chmod, chown.
+// Like a real filesystem, WriteFile and AppendFile fail if the parent directory does not exist.
+// Other commands and scripts succeed with no output, unless a Result is set for them.
+type BaseConfigurer struct {
+	Log        io.Writer
+	Files      map[string]*File
+	Results    map[string]Result
+	Operations []*plan.Operation
+}
+
+// NewBaseConfigurer creates a BaseConfigurer with an empty filesystem.
+func NewBaseConfigurer() *BaseConfigurer {
+	return &BaseConfigurer{
+		Files:   map[string]*File{"/": &File{Dir: true, Mode: fs.ModeDir | 0755}},
+		Results: make(map[string]Result),
+	}
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+// SetResult sets the result of a command or script.
+// A command is identified by its args joined with spaces.
+func (t *BaseConfigurer) SetResult(command string, output string, err error) {
+	t.Results[command] = Result{Output: output, Err: err}
+}
+
+// AddFile adds a file to the filesystem, creating its parent directories.
+func (t *BaseConfigurer) AddFile(file string, data []byte, perm fs.FileMode) {
+	t.mkdirAll(path.Dir(file))
+	t.Files[path.Clean(file)] = &File{Data: data, Mode: perm}
+}
+
+func (t *BaseConfigurer) add(op *plan.Operation) {
+	t.Operations = append(t.Operations, op)
+}
+
+func (t *BaseConfigurer) result(command string) error {
+	r, scripted := t.Results[command]
+	if scripted && r.Times > 0 {
+		if r.Times == 1 {
+			t.Results[command] = Result{}
+		} else {
+			t.Results[command] = Result{Output: r.Output, Err: r.Err, Times: r.Times - 1}
+		}
+	}
+	if r.Output != "" && t.Log != nil {
+		io.WriteString(t.Log, r.Output)
+	}
+	return r.Err
+}
+
+func (t *BaseConfigurer) mkdirAll(dir string) error {
+	dir = path.Clean(dir)
+	if f, exists := t.Files[dir]; exists {
+		if !f.Dir {
+			return fmt.Errorf("%s: not a directory", dir)
+		}
+		return nil
+	}
+	err := t.mkdirAll(path.Dir(dir))
+	if err != nil {
+		return err
+	}
+	t.Files[dir] = &File{Dir: true, Mode: fs.ModeDir | 0755}
+	return nil
+}
+
+func (t *BaseConfigurer) BeginSection(name string) {
+	t.add(&plan.Operation{Type: plan.OpSection, Section: name})
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	t.add(&plan.Operation{Type: plan.OpScript, Script: script})
+	return t.result(script)
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	t.add(&plan.Operation{Type: plan.OpCommand, Args: args})
+	command := strings.Join(args, " ")
+	if _, scripted := t.Results[command]; scripted {
+		return t.result(command)
+	}
+	if len(args) != 3 {
+		return nil
+	}
+	switch {
+	case args[0] == "mkdir" && args[1] == "-p":
+		return t.mkdirAll(args[2])
+	case args[0] == "chmod":
+		f, err := t.file(args[2])
+		if err != nil {
+			return err
+		}
+		mode, err := strconv.ParseUint(args[1], 8, 32)
+		if err != nil {
+			return fmt.Errorf("chmod: invalid mode: %s", args[1])
+		}
+		f.Mode = f.Mode.Type() | fs.FileMode(mode)
+	case args[0] == "chown":
+		f, err := t.file(args[2])
+		if err != nil {
+			return err
+		}
+		f.Owner = args[1]
+	}
+	return nil
+}
+
+func (t *BaseConfigurer) file(file string) (*File, error) {
+	f, exists := t.Files[path.Clean(file)]
+	if !exists {
+		return nil, &fs.PathError{Op: "open", Path: file, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// create returns a file, creating it with perm if it does not exist.
+func (t *BaseConfigurer) create(op string, file string, perm fs.FileMode) (*File, error) {
+	file = path.Clean(file)
+	if f, exists := t.Files[file]; exists {
+		if f.Dir {
+			return nil, &fs.PathError{Op: op, Path: file, Err: fmt.Errorf("is a directory")}
+		}
+		return f, nil
+	}
+	dir, exists := t.Files[path.Dir(file)]
+	if !exists || !dir.Dir {
+		return nil, &fs.PathError{Op: op, Path: file, Err: fs.ErrNotExist}
+	}
+	f := &File{Mode: perm.Perm()}
+	t.Files[file] = f
+	return f, nil
+}
+
+func (t *BaseConfigurer) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	t.add(&plan.Operation{Type: plan.OpWriteFile, Path: path, Data: data, Perm: perm})
+	f, err := t.create("write", path, perm)
+	if err != nil {
+		return err
+	}
+	f.Data = append([]byte(nil), data...)
+	return nil
+}
+
+func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode) error {
+	t.add(&plan.Operation{Type: plan.OpAppendFile, Path: path, Data: data, Perm: perm})
+	f, err := t.create("append", path, perm)
+	if err != nil {
+		return err
+	}
+	f.Data = append(f.Data, data...)
+	return nil
+}
+
+func (t *BaseConfigurer) FileExists(path string) (bool, error) {
+	t.add(&plan.Operation{Type: plan.OpFileExists, Path: path})
+	_, err := t.file(path)
+	return err == nil, nil
+}
+
+// Commands returns the commands that were run, with their args joined by spaces.
+func (t *BaseConfigurer) Commands() []string {
+	var commands []string
+	for _, op := range t.Operations {
+		if op.Type == plan.OpCommand {
+			commands = append(commands, strings.Join(op.Args, " "))
+		}
+	}
+	return commands
+}
+
+// Paths returns the paths of the files, without the directories, sorted.
+func (t *BaseConfigurer) Paths() []string {
+	var paths []string
+	for p, f := range t.Files {
+		if !f.Dir {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// AssertFile checks that a file exists, with the given content and permissions.
+func (t *BaseConfigurer) AssertFile(tb testing.TB, path string, content string, perm fs.FileMode) {
+	tb.Helper()
+	f, err := t.file(path)
+	if err != nil || f.Dir {
+		tb.Errorf("%s: file does not exist", path)
+		return
+	}
+	if !bytes.Equal(f.Data, []byte(content)) {
+		tb.Errorf("%s: content %q, expected %q", path, string(f.Data), content)
+	}
+	if f.Mode.Perm() != perm.Perm() {
+		tb.Errorf("%s: mode %04o, expected %04o", path, f.Mode.Perm(), perm.Perm())
+	}
+}
+
+// AssertOwner checks that a file or directory has the given owner, as passed to chown.
+func (t *BaseConfigurer) AssertOwner(tb testing.TB, path string, owner string) {
+	tb.Helper()
+	f, err := t.file(path)
+	if err != nil {
+		tb.Errorf("%s: does not exist", path)
+		return
+	}
+	if f.Owner != owner {
+		tb.Errorf("%s: owner %q, expected %q", path, f.Owner, owner)
+	}
+}
+
+// AssertNoFile checks that a file or directory does not exist.
+func (t *BaseConfigurer) AssertNoFile(tb testing.TB, path string) {
+	tb.Helper()
+	if _, err := t.file(path); err == nil {
+		tb.Errorf("%s: exists", path)
+	}
+}
+
+// AssertRan checks that a command was run with the given args.
+func (t *BaseConfigurer) AssertRan(tb testing.TB, args ...string) {
+	tb.Helper()
+	command := strings.Join(args, " ")
+	for _, c := range t.Commands() {
+		if c == command {
+			return
+		}
+	}
+	tb.Errorf("command did not run: %s", command)
+}
+
+// AssertScript checks that a script that contains s was run.
+func (t *BaseConfigurer) AssertScript(tb testing.TB, s string) {
+	tb.Helper()
+	for _, op := range t.Operations {
+		if op.Type == plan.OpScript && strings.Contains(op.Script, s) {
+			return
+		}
+	}
+	tb.Errorf("no script contains: %s", s)
+}
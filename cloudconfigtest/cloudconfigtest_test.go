@@ -0,0 +1,58 @@
+package cloudconfigtest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"melato.org/cloudconfig"
+)
+
+func TestInterfaces(t *testing.T) {
+	base := NewBaseConfigurer()
+	var _ cloudconfig.BaseConfigurer = base
+	var _ cloudconfig.BaseSection = base
+}
+
+func TestFilesystem(t *testing.T) {
+	base := NewBaseConfigurer()
+	if base.WriteFile("/etc/a", []byte("a"), 0600) == nil {
+		t.Fatalf("WriteFile should not create directories")
+	}
+	base.AddFile("/etc/a", []byte("a"), 0600)
+	err := base.AppendFile("/etc/a", []byte("b"), 0644)
+	if err == nil {
+		err = base.AppendFile("/etc/b", []byte("b"), 0644)
+	}
+	if err == nil {
+		err = base.RunCommand("chmod", "0640", "/etc/b")
+	}
+	if err == nil {
+		err = base.RunCommand("chown", "a:b", "/etc/b")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.AssertFile(t, "/etc/a", "ab", 0600)
+	base.AssertFile(t, "/etc/b", "b", 0640)
+	base.AssertOwner(t, "/etc/b", "a:b")
+	base.AssertNoFile(t, "/etc/c")
+	exists, err := base.FileExists("/etc")
+	if err != nil || !exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+}
+
+func TestResults(t *testing.T) {
+	base := NewBaseConfigurer()
+	var log strings.Builder
+	base.SetLogWriter(&log)
+	base.SetResult("apk add x", "not found\n", errors.New("exit status 1"))
+	if base.RunCommand("apk", "add", "x") == nil {
+		t.Fatalf("expected error")
+	}
+	if log.String() != "not found\n" {
+		t.Fatalf("%q", log.String())
+	}
+	base.AssertRan(t, "apk", "add", "x")
+}
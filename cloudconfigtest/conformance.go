@@ -0,0 +1,157 @@
+package cloudconfigtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+
+	"melato.org/cloudconfig"
+)
+
+// Factory creates a BaseConfigurer for a conformance test,
+// and returns an empty, writable directory on its target, for the test files.
+// The target must have sh, cat, and ls.
+type Factory func(t *testing.T) (base cloudconfig.BaseConfigurer, dir string)
+
+// conformance wraps a BaseConfigurer under test, with its log and test directory.
+type conformance struct {
+	t    *testing.T
+	base cloudconfig.BaseConfigurer
+	dir  string
+	log  bytes.Buffer
+}
+
+func newConformance(t *testing.T, factory Factory) *conformance {
+	c := &conformance{t: t}
+	c.base, c.dir = factory(t)
+	c.base.SetLogWriter(&c.log)
+	return c
+}
+
+func (c *conformance) path(name string) string {
+	return path.Join(c.dir, name)
+}
+
+// output runs a script and returns its output.
+func (c *conformance) output(script string) string {
+	c.t.Helper()
+	c.log.Reset()
+	err := c.base.RunScript(script)
+	if err != nil {
+		c.t.Fatalf("%s: %v", strings.TrimSpace(script), err)
+	}
+	return c.log.String()
+}
+
+// checkFile checks the content and permissions of a file, using cat and ls.
+func (c *conformance) checkFile(file string, content string, perm fs.FileMode) {
+	c.t.Helper()
+	data := c.output("cat " + cloudconfig.ShellQuote(file) + "\n")
+	if data != content {
+		c.t.Errorf("%s: content %q, expected %q", file, data, content)
+	}
+	fields := strings.Fields(c.output("ls -ld " + cloudconfig.ShellQuote(file) + "\n"))
+	if len(fields) == 0 || fields[0][1:10] != perm.String()[1:10] {
+		c.t.Errorf("%s: mode %v, expected %v", file, fields, perm)
+	}
+}
+
+func (c *conformance) checkExists(file string, expected bool) {
+	c.t.Helper()
+	exists, err := c.base.FileExists(file)
+	if err != nil {
+		c.t.Errorf("FileExists(%s): %v", file, err)
+	} else if exists != expected {
+		c.t.Errorf("FileExists(%s) = %v, expected %v", file, exists, expected)
+	}
+}
+
+// RunConformance checks that a BaseConfigurer follows the contract of cloudconfig.BaseConfigurer:
+//   - WriteFile creates or truncates a file, with the given permissions for new files.
+//   - AppendFile creates a file, or appends to it.
+//   - WriteFile and AppendFile do not create directories.
+//   - FileExists reports files and directories, without an error for missing files.
+//   - RunCommand and RunScript write their output to the log writer,
+//     and return an error if the command fails.
+//
+// Permissions are chosen so that they are not affected by a umask of 022.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("WriteFile", func(t *testing.T) {
+		c := newConformance(t, factory)
+		file := c.path("a")
+		err := c.base.WriteFile(file, []byte("abc\n"), 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.checkFile(file, "abc\n", 0640)
+		err = c.base.WriteFile(file, []byte("d\n"), 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.checkFile(file, "d\n", 0640)
+	})
+	t.Run("AppendFile", func(t *testing.T) {
+		c := newConformance(t, factory)
+		file := c.path("a")
+		err := c.base.AppendFile(file, []byte("a\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.checkFile(file, "a\n", 0600)
+		err = c.base.AppendFile(file, []byte("b\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.checkFile(file, "a\nb\n", 0600)
+	})
+	t.Run("NoDirectories", func(t *testing.T) {
+		c := newConformance(t, factory)
+		if c.base.WriteFile(c.path("d/a"), []byte("a"), 0644) == nil {
+			t.Errorf("WriteFile created a directory")
+		}
+		if c.base.AppendFile(c.path("d/b"), []byte("b"), 0644) == nil {
+			t.Errorf("AppendFile created a directory")
+		}
+		c.checkExists(c.path("d"), false)
+	})
+	t.Run("FileExists", func(t *testing.T) {
+		c := newConformance(t, factory)
+		c.checkExists(c.dir, true)
+		c.checkExists(c.path("a"), false)
+		c.checkExists(c.path("d/a"), false)
+		err := c.base.WriteFile(c.path("a"), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.checkExists(c.path("a"), true)
+	})
+	t.Run("RunCommand", func(t *testing.T) {
+		c := newConformance(t, factory)
+		err := c.base.RunCommand("echo", "a b", "it's")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.log.String() != "a b it's\n" {
+			t.Errorf("output: %q", c.log.String())
+		}
+		if c.base.RunCommand("false") == nil {
+			t.Errorf("false did not fail")
+		}
+		if c.base.RunCommand() == nil {
+			t.Errorf("empty command did not fail")
+		}
+	})
+	t.Run("RunScript", func(t *testing.T) {
+		c := newConformance(t, factory)
+		output := c.output(fmt.Sprintf("cd '%s'\necho a > a\ncat a\n", c.dir))
+		if output != "a\n" {
+			t.Errorf("output: %q", output)
+		}
+		if c.base.RunScript("exit 3\n") == nil {
+			t.Errorf("script did not fail")
+		}
+	})
+}
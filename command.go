@@ -0,0 +1,155 @@
+package cloudconfig
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CommandOptions are options for running a command, for BaseCommandOptions.
+type CommandOptions struct {
+	// Env has environment variables, as NAME=value, that are added to the environment of the command.
+	Env []string
+	// Dir is the working directory of the command.
+	Dir string
+	// User is the user that runs the command.
+	User string
+	// Stdin is the input of the command.
+	Stdin string
+	// Output, if not nil, captures the standard output of the command, instead of the log writer.
+	Output io.Writer
+}
+
+// Command is a runcmd entry in the map form, which is an extension of cloud-init:
+//
+//	runcmd:
+//	- cmd: [make, install]
+//	  user: deploy
+//	  dir: /srv/app
+//	  env: {PREFIX: /usr/local}
+//	  stdin: "..."
+//
+// cmd may be a list of args or a string, which is run with sh -c.
+type Command struct {
+	Args    []string
+	Script  string
+	Options CommandOptions
+}
+
+// The keys of the runcmd map form
+const (
+	CommandKeyCmd   = "cmd"
+	CommandKeyUser  = "user"
+	CommandKeyDir   = "dir"
+	CommandKeyEnv   = "env"
+	CommandKeyStdin = "stdin"
+)
+
+// CommandMap parses a command in the map form.
+// It returns false if the command is not a map.
+func CommandMap(command any) (*Command, bool, error) {
+	switch command.(type) {
+	case map[string]any, map[any]any:
+	default:
+		return nil, false, nil
+	}
+	m := NormalizeYaml(command).(map[string]any)
+	c := &Command{}
+	toString := func(key string, v any) (string, error) {
+		s, isString := v.(string)
+		if !isString {
+			return "", fmt.Errorf("%s: expected string, found %T", key, v)
+		}
+		return s, nil
+	}
+	var err error
+	for _, key := range sortedMapKeys(m) {
+		v := m[key]
+		switch key {
+		case CommandKeyCmd:
+			if script, isScript := CommandScript(v); isScript {
+				c.Script = script
+			} else if args, isArgs := CommandArgs(v); isArgs {
+				c.Args = args
+			} else {
+				err = fmt.Errorf("cmd: expected string or list, found %T", v)
+			}
+		case CommandKeyUser:
+			c.Options.User, err = toString(key, v)
+		case CommandKeyDir:
+			c.Options.Dir, err = toString(key, v)
+		case CommandKeyStdin:
+			c.Options.Stdin, err = toString(key, v)
+		case CommandKeyEnv:
+			env, isMap := v.(map[string]any)
+			if !isMap {
+				err = fmt.Errorf("env: expected map, found %T", v)
+				break
+			}
+			for _, name := range sortedMapKeys(env) {
+				c.Options.Env = append(c.Options.Env, fmt.Sprintf("%s=%v", name, env[name]))
+			}
+		default:
+			err = fmt.Errorf("unknown command key: %s", key)
+		}
+		if err != nil {
+			return nil, true, err
+		}
+	}
+	if c.Script == "" && len(c.Args) == 0 {
+		return nil, true, fmt.Errorf("missing cmd")
+	}
+	return c, true, nil
+}
+
+func sortedMapKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CommandArgs returns the args to run, with sh -c for a script.
+func (t *Command) CommandArgs() []string {
+	if t.Script != "" {
+		return []string{"sh", "-c", t.Script}
+	}
+	return t.Args
+}
+
+// ShellQuote quotes a string for sh, if necessary.
+func ShellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ShellScript returns a sh script that runs the command with its options, except Output,
+// using env, cd, su, and printf.
+// It is used for BaseConfigurers that do not implement BaseCommandOptions.
+func (t *Command) ShellScript() string {
+	var words []string
+	if len(t.Options.Env) > 0 {
+		words = append(words, "env")
+		words = append(words, t.Options.Env...)
+	}
+	words = append(words, t.CommandArgs()...)
+	for i, word := range words {
+		words[i] = ShellQuote(word)
+	}
+	script := strings.Join(words, " ")
+	if t.Options.Dir != "" {
+		script = "cd " + ShellQuote(t.Options.Dir) + " && " + script
+	}
+	if t.Options.User != "" {
+		script = "su -s /bin/sh " + ShellQuote(t.Options.User) + " -c " + ShellQuote(script)
+	}
+	if t.Options.Stdin != "" {
+		script = "printf '%s' " + ShellQuote(t.Options.Stdin) + " | " + script
+	}
+	return script + "\n"
+}
@@ -0,0 +1,70 @@
+package cloudconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandMap(t *testing.T) {
+	config, err := Unmarshal([]byte(`#cloud-config
+runcmd:
+- cmd: [make, install]
+  user: deploy
+  dir: /srv/app
+  env: {PREFIX: /usr/local, A: 1}
+- {cmd: "echo $A", stdin: x}
+- [echo, a]
+- {user: a}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, isMap, err := CommandMap(config.Runcmd[0])
+	if !isMap || err != nil {
+		t.Fatalf("%v %v", isMap, err)
+	}
+	if strings.Join(c.Args, " ") != "make install" || c.Options.User != "deploy" || c.Options.Dir != "/srv/app" {
+		t.Fatalf("%v", c)
+	}
+	if strings.Join(c.Options.Env, " ") != "A=1 PREFIX=/usr/local" {
+		t.Fatalf("%v", c.Options.Env)
+	}
+	script := "printf '%s' x | sh -c 'echo $A'\n"
+	c, _, _ = CommandMap(config.Runcmd[1])
+	if c.ShellScript() != script {
+		t.Fatalf("%q", c.ShellScript())
+	}
+	_, isMap, _ = CommandMap(config.Runcmd[2])
+	if isMap {
+		t.Fatalf("list is not a map")
+	}
+	_, _, err = CommandMap(config.Runcmd[3])
+	if err == nil {
+		t.Fatalf("expected missing cmd")
+	}
+}
+
+func TestCommandShellScript(t *testing.T) {
+	c := &Command{Args: []string{"make", "install"}, Options: CommandOptions{
+		Env:  []string{"A=b c"},
+		Dir:  "/srv/app",
+		User: "deploy",
+	}}
+	expected := `su -s /bin/sh deploy -c 'cd /srv/app && env '\''A=b c'\'' make install'` + "\n"
+	if c.ShellScript() != expected {
+		t.Fatalf("%s", c.ShellScript())
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for s, expected := range map[string]string{
+		"a/b":  "a/b",
+		"":     "''",
+		"a b":  "'a b'",
+		"it's": `'it'\''s'`,
+	} {
+		if q := ShellQuote(s); q != expected {
+			t.Errorf("%s: %s", s, q)
+		}
+	}
+}
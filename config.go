@@ -7,13 +7,23 @@ const Comment = "#cloud-config"
 type Config struct {
 	//PackageUpdate  bool     `yaml:"package_update",omitempty`
 	//PackageUpgrade bool     `yaml:"package_upgrade",omitempty`
-	Packages []string `yaml:"packages,omitempty"`
-	Files    []*File  `yaml:"write_files,omitempty"`
-	Users    []*User  `yaml:"users,omitempty"`
-	Timezone string   `yaml:"timezone,omitempty"`
+	Packages []string `yaml:"packages,omitempty" json:"packages,omitempty"`
+	Files    []*File  `yaml:"write_files,omitempty" json:"write_files,omitempty"`
+	Users    []*User  `yaml:"users,omitempty" json:"users,omitempty"`
+	Timezone string   `yaml:"timezone,omitempty" json:"timezone,omitempty"`
 
 	// Runcmd is a list of commands to run
-	Runcmd Commands `yaml:"runcmd,omitempty"`
+	Runcmd Commands `yaml:"runcmd,omitempty" json:"runcmd,omitempty"`
+
+	// MergeHow specifies how this config is merged with previous configs.
+	// It is a string or a list of mergers.  See Merge.
+	MergeHow any `yaml:"merge_how,omitempty" json:"merge_how,omitempty"`
+	// MergeType is an alias for MergeHow
+	MergeType any `yaml:"merge_type,omitempty" json:"merge_type,omitempty"`
+
+	// Extra has the keys that are not otherwise supported.
+	// They are not applied, but they are preserved when marshalling.
+	Extra map[string]any `yaml:",inline" json:"-"`
 }
 
 // Commands is a list of commands.
@@ -25,24 +35,26 @@ type Config struct {
 type Commands []any
 
 type File struct {
-	Path        string `yaml:"path`
-	Owner       string `yaml:"owner,omitempty`
-	Permissions string `yaml:"permissions,omitempty`
-	Content     string `yaml:"content`
-	Append      bool   `yaml:"append`
-	Defer       bool   `yaml:"defer`
+	Path        string `yaml:"path" json:"path"`
+	Owner       string `yaml:"owner,omitempty" json:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+	Content     string `yaml:"content" json:"content"`
+	Append      bool   `yaml:"append,omitempty" json:"append,omitempty"`
+	Defer       bool   `yaml:"defer,omitempty" json:"defer,omitempty"`
+	// Extra has unsupported keys, such as encoding.
+	Extra map[string]any `yaml:",inline" json:"-"`
 }
 
 type User struct {
-	Name              string   `yaml:"name"`
-	Uid               string   `yaml:"uid,omitempty"`
-	Shell             string   `yaml:"shell,omitempty"`
-	Homedir           string   `yaml:"homedir,omitempty"`
-	NoCreateHome      bool     `yaml:"no_create_home,omitempty"`
-	PrimaryGroup      string   `yaml:"primary_group,omitempty"`
-	Groups            string   `yaml:"groups,omitempty"`
-	Gecos             string   `yaml:"gecos,omitempty"`
-	SshAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	Name              string   `yaml:"name" json:"name"`
+	Uid               string   `yaml:"uid,omitempty" json:"uid,omitempty"`
+	Shell             string   `yaml:"shell,omitempty" json:"shell,omitempty"`
+	Homedir           string   `yaml:"homedir,omitempty" json:"homedir,omitempty"`
+	NoCreateHome      bool     `yaml:"no_create_home,omitempty" json:"no_create_home,omitempty"`
+	PrimaryGroup      string   `yaml:"primary_group,omitempty" json:"primary_group,omitempty"`
+	Groups            string   `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Gecos             string   `yaml:"gecos,omitempty" json:"gecos,omitempty"`
+	SshAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty" json:"ssh_authorized_keys,omitempty"`
 	/* sudo may be true, false, nil, a string, or a []string
 	If it is false or nil, it does nothing
 	If the directory /etc/sudoers.d/ exists, a file is created there,
@@ -56,5 +68,7 @@ type User struct {
 	doas and sudo configurations are not compatible, so specifying strings instead of true
 	makes sense if only one of the above directories exists.
 	*/
-	Sudo any `yaml:"sudo,omitempty"`
+	Sudo any `yaml:"sudo,omitempty" json:"sudo,omitempty"`
+	// Extra has unsupported keys, such as lock_passwd.
+	Extra map[string]any `yaml:",inline" json:"-"`
 }
@@ -3,6 +3,7 @@ package cloudconfig
 import (
 	"embed"
 	"io/fs"
+	"strings"
 	"testing"
 )
 
@@ -54,3 +55,112 @@ func TestHasCloudConfigComment(t *testing.T) {
 		t.Fatalf("shoudl not have found comment")
 	}
 }
+
+func TestUnmarshalJSON(t *testing.T) {
+	data := []byte(`{"packages": ["a"], "write_files": [{"path": "/tmp/a", "content": "a", "defer": true}], "runcmd": [["echo", 1]]}`)
+	c, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"a"}, c.Packages) || len(c.Files) != 1 || !c.Files[0].Defer {
+		t.Fatalf("%v", c)
+	}
+	args, _ := CommandArgs(c.Runcmd[0])
+	if !stringSliceEquals([]string{"echo", "1"}, args) {
+		t.Fatalf("%v", args)
+	}
+	data, err = MarshalJSON(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Files[0].Path != "/tmp/a" {
+		t.Fatalf("%s", string(data))
+	}
+}
+
+func TestExtraRoundTrip(t *testing.T) {
+	data := []byte(`#cloud-config
+package_update: true
+write_files:
+- path: /tmp/a
+  content: a
+  encoding: text/plain
+users:
+- name: a
+  lock_passwd: false
+bootcmd:
+- [echo, a]
+`)
+	c, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Extra["package_update"] != true || c.Files[0].Extra["encoding"] != "text/plain" ||
+		c.Users[0].Extra["lock_passwd"] != false {
+		t.Fatalf("%v", c)
+	}
+	for _, marshal := range []func(*Config) ([]byte, error){Marshal, MarshalJSON} {
+		out, err := marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c2, err := Unmarshal(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c2.Extra["package_update"] != true || c2.Files[0].Extra["encoding"] != "text/plain" ||
+			c2.Users[0].Extra["lock_passwd"] != false || c2.Extra["bootcmd"] == nil {
+			t.Fatalf("%s", string(out))
+		}
+	}
+}
+
+func TestExtraJSONCaseInsensitive(t *testing.T) {
+	data := []byte(`{"Packages": ["a"], "write_files": [{"Path": "/tmp/a", "content": "a"}], "bootcmd": ["true"]}`)
+	c, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"a"}, c.Packages) || c.Files[0].Path != "/tmp/a" {
+		t.Fatalf("%v", c)
+	}
+	if len(c.Extra) != 1 || c.Extra["bootcmd"] == nil || c.Files[0].Extra != nil {
+		t.Fatalf("%v %v", c.Extra, c.Files[0].Extra)
+	}
+	out, err := MarshalJSON(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(strings.ToLower(string(out)), `"packages"`) != 1 {
+		t.Fatalf("%s", string(out))
+	}
+}
+
+func TestUnmarshalJSONNumbers(t *testing.T) {
+	data := []byte(`{"write_files": [{"path": "/tmp/a", "permissions": 420}], "users": [{"name": "a", "uid": 1000}]}`)
+	c, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Files[0].Permissions != "0644" || c.Users[0].Uid != "1000" {
+		t.Fatalf("%v %v", c.Files[0], c.Users[0])
+	}
+	if c.Files[0].Extra != nil || c.Users[0].Extra != nil {
+		t.Fatalf("%v %v", c.Files[0].Extra, c.Users[0].Extra)
+	}
+	out, err := MarshalJSON(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := Unmarshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Files[0].Permissions != "0644" || c2.Users[0].Uid != "1000" {
+		t.Fatalf("%s", string(out))
+	}
+}
@@ -2,6 +2,8 @@ package cloudconfig
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -9,15 +11,60 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var Trace bool
 
+// Sections, in the order in which they are applied.
+const (
+	SectionBoothooks     = "boothooks"
+	SectionWriteFiles    = "write_files"
+	SectionPackages      = "packages"
+	SectionUsers         = "users"
+	SectionTimezone      = "timezone"
+	SectionDeferredFiles = "write_files_deferred"
+	SectionRuncmd        = "runcmd"
+	SectionScripts       = "scripts"
+)
+
+// Directories where user-data scripts are written before they are run.
+const (
+	ScriptsDir   = "/var/lib/cloud/instance/scripts"
+	BoothooksDir = "/var/lib/cloud/instance/boothooks"
+)
+
 type Configurer struct {
-	Base        BaseConfigurer
-	OS          OSType
-	Log         io.Writer
+	Base BaseConfigurer
+	OS   OSType
+	Log  io.Writer
+	// CommandTimeout is the maximum duration of each runcmd entry and each user-data script.  0 means no limit.
+	CommandTimeout time.Duration
+	// PackageTimeout is the maximum duration of each package install command.  0 means no limit.
+	PackageTimeout time.Duration
+	// PackageRetry is the retry policy of each package install.
+	PackageRetry RetryPolicy
+	// WaitPackageLock waits for the package manager lock before each package install attempt,
+	// if OS implements OSInstallPackageWait or OSWaitPackageLock.
+	WaitPackageLock bool
+	// CommandRetry is the retry policy of each runcmd entry.
+	CommandRetry RetryPolicy
+	// Report, if not nil, records each step.
+	// While applying, the base configurer writes its output to Report,
+	// and when done, its log writer is set to Report.Output.
+	Report *Report
+	// OnError specifies whether to stop at the first failed step.
+	// With the continue policies, Apply returns the errors of all the failed steps, joined with errors.Join.
+	OnError ErrorPolicy
+	// Fetcher fetches #include URLs in ApplyConfigFiles and ApplyStdin.  If it is nil, #include is not supported.
+	Fetcher     Fetcher
 	createdDirs map[string]struct{}
+	section     string
+	stepIndex   int
+	reporting   bool
+	continuing  bool
+	retrying    bool
+	failures    []error
 }
 
 // NewConfigurer creates a Configurer
@@ -33,7 +80,114 @@ func (t *Configurer) logf(format string, args ...any) {
 	}
 }
 
-func (t *Configurer) ensureDirExists(dir string) error {
+func (t *Configurer) beginSection(name string) {
+	t.section = name
+	t.stepIndex = 0
+	section, ok := t.Base.(BaseSection)
+	if ok {
+		section.BeginSection(name)
+	}
+}
+
+// endReporting sets the log writer of the base configurer to Report.Output, after step has set it to Report.
+func (t *Configurer) endReporting() {
+	if t.reporting {
+		t.Base.SetLogWriter(t.Report.Output)
+		t.reporting = false
+	}
+}
+
+// step runs an operation of the base configurer as a step, and records it in the Report.
+// If the operation fails, it returns a *StepError, or records it and returns nil, if the OnError policy continues
+// and the step will not be retried.
+func (t *Configurer) step(command string, run func() error) error {
+	if t.Report != nil && !t.reporting {
+		t.Base.SetLogWriter(t.Report)
+		t.reporting = true
+	}
+	step := &Step{Section: t.section, Index: t.stepIndex, Command: command}
+	t.stepIndex++
+	if t.Report != nil {
+		t.Report.tail = t.Report.tail[:0]
+	}
+	start := time.Now()
+	err := run()
+	step.Duration = time.Since(start)
+	step.ExitCode = exitCode(err)
+	if err != nil {
+		step.Error = err.Error()
+	}
+	if t.Report != nil {
+		step.Output = string(t.Report.tail)
+		t.Report.Steps = append(t.Report.Steps, step)
+	}
+	if err != nil {
+		stepError := &StepError{Step: step, Err: err}
+		step.Retried = t.retrying
+		if !t.retrying && t.failed(stepError) {
+			return nil
+		}
+		return stepError
+	}
+	return nil
+}
+
+// runBaseCommand runs a command with the base configurer, with ctx if it implements BaseContext.
+func (t *Configurer) runBaseCommand(ctx context.Context, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.step(strings.Join(args, " "), func() error {
+		if base, ok := t.Base.(BaseContext); ok {
+			return contextError(ctx, base.RunCommandContext(ctx, args...))
+		}
+		return t.Base.RunCommand(args...)
+	})
+}
+
+// runBaseScript runs a script with the base configurer, with ctx if it implements BaseContext.
+func (t *Configurer) runBaseScript(ctx context.Context, script string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.step("sh: "+firstLine(script), func() error {
+		if base, ok := t.Base.(BaseContext); ok {
+			return contextError(ctx, base.RunScriptContext(ctx, script))
+		}
+		return t.Base.RunScript(script)
+	})
+}
+
+// writeBaseFile writes or appends to a file with the base configurer.
+func (t *Configurer) writeBaseFile(path string, data []byte, perm fs.FileMode, isAppend bool) error {
+	if isAppend {
+		return t.step("append "+path, func() error {
+			return t.Base.AppendFile(path, data, perm)
+		})
+	}
+	return t.step("write "+path, func() error {
+		return t.Base.WriteFile(path, data, perm)
+	})
+}
+
+// contextError wraps the error of a command that was stopped because ctx expired,
+// so that errors.Is(err, context.DeadlineExceeded) works.
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	return err
+}
+
+// withTimeout returns a context with a timeout, if timeout is not 0.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (t *Configurer) ensureDirExists(ctx context.Context, dir string) error {
 	if dir == "/" || dir == "." {
 		return nil
 	}
@@ -41,7 +195,7 @@ func (t *Configurer) ensureDirExists(dir string) error {
 	if exists {
 		return nil
 	}
-	err := t.Base.RunCommand("mkdir", "-p", dir)
+	err := t.runBaseCommand(ctx, "mkdir", "-p", dir)
 	if err != nil {
 		return err
 	}
@@ -52,11 +206,15 @@ func (t *Configurer) ensureDirExists(dir string) error {
 }
 
 func (t *Configurer) WriteFile(f *File) error {
+	return t.writeFile(context.Background(), f)
+}
+
+func (t *Configurer) writeFile(ctx context.Context, f *File) error {
 	var perm fs.FileMode
 	if f.Permissions != "" {
 		mode, err := strconv.ParseInt(f.Permissions, 8, 32)
 		if err != nil {
-			return err
+			return t.invalidStep("write "+f.Path, fmt.Errorf("invalid permissions: %w", err))
 		}
 		perm = fs.FileMode(mode)
 	} else {
@@ -65,20 +223,19 @@ func (t *Configurer) WriteFile(f *File) error {
 	}
 	t.logf("write file: %s\n", f.Path)
 	dir := filepath.Dir(f.Path)
-	err := t.ensureDirExists(dir)
+	err := t.ensureDirExists(ctx, dir)
 	if err != nil {
 		return err
 	}
-	if f.Append {
-		err = t.Base.AppendFile(f.Path, []byte(f.Content), perm)
-	} else {
-		err = t.Base.WriteFile(f.Path, []byte(f.Content), perm)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	err = t.writeBaseFile(f.Path, []byte(f.Content), perm, f.Append)
 	if err != nil {
 		return err
 	}
 	if f.Owner != "" {
-		err := t.Base.RunCommand("chown", f.Owner, f.Path)
+		err := t.runBaseCommand(ctx, "chown", f.Owner, f.Path)
 		if err != nil {
 			return err
 		}
@@ -87,9 +244,13 @@ func (t *Configurer) WriteFile(f *File) error {
 }
 
 func (t *Configurer) WriteFiles(files []*File, defered bool) error {
+	return t.writeFiles(context.Background(), files, defered)
+}
+
+func (t *Configurer) writeFiles(ctx context.Context, files []*File, defered bool) error {
 	for _, f := range files {
 		if f.Defer == defered {
-			err := t.WriteFile(f)
+			err := t.writeFile(ctx, f)
 			if err != nil {
 				return err
 			}
@@ -98,65 +259,108 @@ func (t *Configurer) WriteFiles(files []*File, defered bool) error {
 	return nil
 }
 
+func hasFiles(files []*File, defered bool) bool {
+	for _, f := range files {
+		if f.Defer == defered {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Configurer) Apply(config *Config) error {
+	return t.ApplyContext(context.Background(), config)
+}
+
+// ApplyContext applies a config.
+// It stops when ctx is done, and stops running commands if the BaseConfigurer implements BaseContext.
+func (t *Configurer) ApplyContext(ctx context.Context, config *Config) error {
+	return t.collect(func() error {
+		return t.applyConfig(ctx, config)
+	})
+}
+
+// applyConfig applies the sections of a config, calling endSection after each section.
+func (t *Configurer) applyConfig(ctx context.Context, config *Config) error {
 	if t.Base == nil {
 		return fmt.Errorf("missing base configurer")
 	}
 	var err error
-	err = t.WriteFiles(config.Files, false)
+	if hasFiles(config.Files, false) {
+		t.beginSection(SectionWriteFiles)
+	}
+	err = t.endSection(ctx, t.writeFiles(ctx, config.Files, false))
 	if err != nil {
 		return err
 	}
-	err = t.InstallPackages(config.Packages)
+	if len(config.Packages) > 0 {
+		t.beginSection(SectionPackages)
+	}
+	err = t.endSection(ctx, t.installPackages(ctx, config.Packages))
 	if err != nil {
 		return err
 	}
-	err = t.AddUsers(config.Users)
+	if len(config.Users) > 0 {
+		t.beginSection(SectionUsers)
+	}
+	err = t.endSection(ctx, t.addUsers(ctx, config.Users))
 	if err != nil {
 		return err
 	}
 	if config.Timezone != "" {
-		if t.OS == nil {
-			return requireOSError("cannot set timezone")
-		}
-		command := t.OS.SetTimezoneCommand(config.Timezone)
-		err := t.RunCommands(Commands{command})
+		t.beginSection(SectionTimezone)
+		err = t.endSection(ctx, t.setTimezone(ctx, config.Timezone))
 		if err != nil {
 			return err
 		}
 	}
-	err = t.WriteFiles(config.Files, true)
-	if err != nil {
-		return err
+	if hasFiles(config.Files, true) {
+		t.beginSection(SectionDeferredFiles)
 	}
-	err = t.RunCommands(config.Runcmd)
+	err = t.endSection(ctx, t.writeFiles(ctx, config.Files, true))
 	if err != nil {
 		return err
 	}
-	return nil
+	if len(config.Runcmd) > 0 {
+		t.beginSection(SectionRuncmd)
+	}
+	return t.endSection(ctx, t.runCommands(ctx, config.Runcmd, t.CommandTimeout, t.CommandRetry))
+}
+
+func (t *Configurer) setTimezone(ctx context.Context, timezone string) error {
+	if t.OS == nil {
+		return requireOSError("cannot set timezone")
+	}
+	return t.runCommands(ctx, Commands{t.OS.SetTimezoneCommand(timezone)}, 0, RetryPolicy{})
 }
 
-// ApplyConfigFiles reads cloud-config files and applies them.
+// ApplyConfigFiles reads user-data files and applies them.
 // It reads them all first before applying any of them.
+// With the ContinueAll policy, it applies all the files, even if some of them fail.
 func (t *Configurer) ApplyConfigFiles(files ...string) error {
-	configs := make([]*Config, len(files))
+	reader := &UserDataReader{Fetcher: t.Fetcher}
+	userData := make([]*UserData, len(files))
 	for i, file := range files {
-		config, err := ReadFile(file)
+		u, err := reader.ReadFile(file)
 		if err != nil {
 			return err
 		}
-		configs[i] = config
+		userData[i] = u
 	}
-	for i, config := range configs {
-		err := t.Apply(config)
+	var errs []error
+	for i, u := range userData {
+		err := t.ApplyUserData(u)
 		if err != nil {
-			return fmt.Errorf("%s: %w", files[i], err)
+			errs = append(errs, fmt.Errorf("%s: %w", files[i], err))
+			if t.OnError != ContinueAll {
+				break
+			}
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-// Apply reads a cloud-config file from stdin and applies it
+// Apply reads user-data from stdin and applies it
 func (t *Configurer) ApplyStdin() error {
 	var buf bytes.Buffer
 	_, err := io.Copy(&buf, os.Stdin)
@@ -164,16 +368,104 @@ func (t *Configurer) ApplyStdin() error {
 		return fmt.Errorf("stdin: %w", err)
 	}
 	data := buf.Bytes()
-	config, err := Unmarshal(data)
+	reader := &UserDataReader{Fetcher: t.Fetcher}
+	u, err := reader.Parse(data)
 	if err != nil {
 		return err
 	}
-	return t.Apply(config)
+	return t.ApplyUserData(u)
+}
+
+// ApplyUserData runs the boothooks, applies the config, and runs the scripts.
+func (t *Configurer) ApplyUserData(u *UserData) error {
+	return t.ApplyUserDataContext(context.Background(), u)
+}
+
+// ApplyUserDataContext is like ApplyUserData, with a context, as in ApplyContext.
+func (t *Configurer) ApplyUserDataContext(ctx context.Context, u *UserData) error {
+	return t.collect(func() error {
+		if len(u.Boothooks) > 0 {
+			t.beginSection(SectionBoothooks)
+		}
+		err := t.endSection(ctx, t.runScripts(ctx, BoothooksDir, u.Boothooks))
+		if err != nil {
+			return err
+		}
+		if u.Config != nil {
+			err = t.applyConfig(ctx, u.Config)
+			if err != nil {
+				return err
+			}
+		}
+		if len(u.Scripts) > 0 {
+			t.beginSection(SectionScripts)
+		}
+		return t.endSection(ctx, t.runScripts(ctx, ScriptsDir, u.Scripts))
+	})
+}
+
+// RunScripts runs user-data scripts in order.
+// A script that starts with #! is written to dir and executed.
+// Other scripts are passed as input to sh.
+func (t *Configurer) RunScripts(dir string, scripts []*Script) error {
+	return t.runScripts(context.Background(), dir, scripts)
+}
+
+// runScripts runs user-data scripts, each with CommandTimeout.
+func (t *Configurer) runScripts(ctx context.Context, dir string, scripts []*Script) error {
+	for i, script := range scripts {
+		if !strings.HasPrefix(script.Content, "#!") {
+			t.logf("script << ---\n")
+			t.logf("%s\n---\n", script.Content)
+			scriptCtx, cancel := withTimeout(ctx, t.CommandTimeout)
+			err := t.runBaseScript(scriptCtx, script.Content)
+			cancel()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		name := filepath.Base(script.Filename)
+		if script.Filename == "" || name == "/" || name == "." {
+			name = fmt.Sprintf("part-%03d", i+1)
+		}
+		path := filepath.Join(dir, name)
+		t.logf("run script: %s\n", path)
+		err := t.ensureDirExists(ctx, dir)
+		if err != nil {
+			return err
+		}
+		err = t.writeBaseFile(path, []byte(script.Content), fs.FileMode(0700), false)
+		if err != nil {
+			return err
+		}
+		scriptCtx, cancel := withTimeout(ctx, t.CommandTimeout)
+		err = t.runBaseCommand(scriptCtx, path)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (t *Configurer) RunCommands(commands Commands) error {
+	return t.runCommands(context.Background(), commands, 0, RetryPolicy{})
+}
+
+// runCommands runs commands in order, each with a timeout, if it is not 0, and a retry policy.
+// An invalid command, such as an empty command, is recorded as a failed step, without running or retrying it,
+// so that the OnError policy applies to it.
+func (t *Configurer) runCommands(ctx context.Context, commands Commands, timeout time.Duration, retry RetryPolicy) error {
 	for _, command := range commands {
-		err := t.runCommand(command)
+		c, isMap, err := parseCommand(command)
+		if err != nil {
+			err = t.invalidStep(command, err)
+		} else {
+			err = t.retry(ctx, retry, func() error {
+				return t.runCommandTimeout(ctx, c, isMap, timeout)
+			})
+		}
 		if err != nil {
 			return err
 		}
@@ -181,38 +473,113 @@ func (t *Configurer) RunCommands(commands Commands) error {
 	return nil
 }
 
-func (t *Configurer) runCommand(command any) error {
-	script, isScript := CommandScript(command)
-	if isScript {
-		t.logf("script << ---\n")
-		t.logf("%s\n---\n", script)
-		return t.Base.RunScript(script)
+// invalidStep records an invalid entry as a failed step.
+func (t *Configurer) invalidStep(entry any, err error) error {
+	return t.step(fmt.Sprint(NormalizeYaml(entry)), func() error {
+		return err
+	})
+}
+
+// parseCommand parses a command in any of its forms.
+// isMap is true for the map form, which may have options.
+func parseCommand(command any) (c *Command, isMap bool, err error) {
+	c, isMap, err = CommandMap(command)
+	if isMap {
+		return c, true, err
+	}
+	if script, isScript := CommandScript(command); isScript {
+		return &Command{Script: script}, false, nil
 	}
-	args, isArgs := CommandArgs(command)
-	if isArgs {
+	if args, isArgs := CommandArgs(command); isArgs {
 		if len(args) == 0 {
-			return fmt.Errorf("empty command")
+			return nil, false, fmt.Errorf("empty command")
 		}
-		if t.Log != nil {
-			t.logf("%s\n", strings.Join(args, " "))
+		return &Command{Args: args}, false, nil
+	}
+	return nil, false, fmt.Errorf("invalid command type: %T", command)
+}
+
+// runCommandTimeout runs a command with a timeout, if it is not 0.
+func (t *Configurer) runCommandTimeout(ctx context.Context, c *Command, isMap bool, timeout time.Duration) error {
+	commandCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	return t.runCommand(commandCtx, c, isMap)
+}
+
+func (t *Configurer) runCommand(ctx context.Context, c *Command, isMap bool) error {
+	if isMap {
+		return t.runCommandOptions(ctx, c)
+	}
+	if c.Script != "" || len(c.Args) == 0 {
+		t.logf("script << ---\n")
+		t.logf("%s\n---\n", c.Script)
+		return t.runBaseScript(ctx, c.Script)
+	}
+	if t.Log != nil {
+		t.logf("%s\n", strings.Join(c.Args, " "))
+	}
+	return t.runBaseCommand(ctx, c.Args...)
+}
+
+// runCommandOptions runs a runcmd entry in the map form.
+func (t *Configurer) runCommandOptions(ctx context.Context, c *Command) error {
+	args := c.CommandArgs()
+	if t.Log != nil {
+		t.logf("%s\n", strings.Join(args, " "))
+	}
+	if base, ok := t.Base.(BaseCommandOptions); ok {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		return t.Base.RunCommand(args...)
+		return t.step(strings.Join(args, " "), func() error {
+			return contextError(ctx, base.RunCommandOptions(ctx, &c.Options, args...))
+		})
 	}
-	return fmt.Errorf("invalid command type: %T", command)
+	if c.Options.Output != nil {
+		return fmt.Errorf("%s: cannot capture output", strings.Join(args, " "))
+	}
+	return t.runBaseScript(ctx, c.ShellScript())
 }
 
 func (t *Configurer) InstallPackages(packages []string) error {
+	return t.installPackages(context.Background(), packages)
+}
+
+// installPackages runs the install command of each package, with PackageTimeout.
+func (t *Configurer) installPackages(ctx context.Context, packages []string) error {
 	if len(packages) == 0 {
 		return nil
 	}
 	if t.OS == nil {
 		return requireOSError("cannot install packages")
 	}
-	commands := make(Commands, 0, len(packages))
+	var wait OSWaitPackageLock
+	var installWait OSInstallPackageWait
+	if t.WaitPackageLock {
+		installWait, _ = t.OS.(OSInstallPackageWait)
+		if installWait == nil {
+			wait, _ = t.OS.(OSWaitPackageLock)
+		}
+	}
 	for _, pkg := range packages {
-		commands = append(commands, t.OS.InstallPackageCommand(pkg))
+		err := t.retry(ctx, t.PackageRetry, func() error {
+			if wait != nil {
+				err := t.runCommandTimeout(ctx, &Command{Script: wait.WaitPackageLockCommand()}, false, t.PackageTimeout)
+				if err != nil {
+					return err
+				}
+			}
+			install := t.OS.InstallPackageCommand(pkg)
+			if installWait != nil {
+				install = installWait.InstallPackageWaitCommand(pkg)
+			}
+			return t.runCommandTimeout(ctx, &Command{Script: install}, false, t.PackageTimeout)
+		})
+		if err != nil {
+			return err
+		}
 	}
-	return t.RunCommands(commands)
+	return nil
 }
 
 func requireOSError(msg string) error {
@@ -225,10 +592,14 @@ func requireOSError(msg string) error {
 // if these directories exist
 // This method is used only if BaseConfigurer does not implement ApplySudo
 func (t *Configurer) ApplySudo(username string, values []string) error {
+	return t.applySudo(context.Background(), username, values)
+}
+
+func (t *Configurer) applySudo(ctx context.Context, username string, values []string) error {
 	commands := make(Commands, 0, 2)
 	commands = append(commands, sudoScript(username, values))
 	commands = append(commands, doasScript(username, values))
-	return t.RunCommands(commands)
+	return t.runCommands(ctx, commands, 0, RetryPolicy{})
 }
 
 func (t *Configurer) chpasswdScript(pass string, users []string) string {
@@ -243,6 +614,10 @@ func (t *Configurer) chpasswdScript(pass string, users []string) string {
 }
 
 func (t *Configurer) AddUsers(users []*User) error {
+	return t.addUsers(context.Background(), users)
+}
+
+func (t *Configurer) addUsers(ctx context.Context, users []*User) error {
 	if len(users) == 0 {
 		return nil
 	}
@@ -260,7 +635,7 @@ func (t *Configurer) AddUsers(users []*User) error {
 			}
 		}
 	}
-	err := t.RunCommands(commands)
+	err := t.runCommands(ctx, commands, 0, RetryPolicy{})
 	if err != nil {
 		return err
 	}
@@ -271,7 +646,7 @@ func (t *Configurer) AddUsers(users []*User) error {
 		}
 
 		script := t.chpasswdScript("*", names)
-		err := t.Base.RunScript(script)
+		err := t.runBaseScript(ctx, script)
 		if err != nil {
 			return err
 		}
@@ -279,22 +654,24 @@ func (t *Configurer) AddUsers(users []*User) error {
 
 	for _, u := range users {
 		if u.Sudo != nil && u.Sudo != false {
-			values, err := toStrings(u.Sudo)
+			values, err := ToStrings(u.Sudo)
 			if err != nil {
 				return fmt.Errorf("invalid sudo value for user %s: %w", u.Name, err)
 			}
-			applySudo, ok := t.Base.(BaseApplySudo)
-			if !ok {
-				applySudo = t
+			if applySudo, ok := t.Base.(BaseApplySudo); ok {
+				err = t.step("sudo "+u.Name, func() error {
+					return applySudo.ApplySudo(u.Name, values)
+				})
+			} else {
+				err = t.applySudo(ctx, u.Name, values)
 			}
-			err = applySudo.ApplySudo(u.Name, values)
 			if err != nil {
 				return err
 			}
 		}
 	}
 	for _, u := range users {
-		err := t.SetAuthorizedKeys(u)
+		err := t.setAuthorizedKeys(ctx, u)
 		if err != nil {
 			return err
 		}
@@ -313,6 +690,10 @@ func (t *Configurer) UserHomeDir(username string) (string, error) {
 }
 
 func (t *Configurer) SetAuthorizedKeys(u *User) error {
+	return t.setAuthorizedKeys(context.Background(), u)
+}
+
+func (t *Configurer) setAuthorizedKeys(ctx context.Context, u *User) error {
 	if len(u.SshAuthorizedKeys) == 0 {
 		return nil
 	}
@@ -341,7 +722,11 @@ func (t *Configurer) SetAuthorizedKeys(u *User) error {
 	for _, key := range u.SshAuthorizedKeys {
 		fmt.Fprintf(&buf, "%s\n", key)
 	}
-	err = t.Base.WriteFile(file, buf.Bytes(), os.FileMode(0600))
+	err = t.ensureDirExists(ctx, dir)
+	if err != nil {
+		return err
+	}
+	err = t.writeBaseFile(file, buf.Bytes(), os.FileMode(0600), false)
 	if err != nil {
 		return err
 	}
@@ -354,7 +739,7 @@ func (t *Configurer) SetAuthorizedKeys(u *User) error {
 		if t.Log != nil {
 			t.logf("%s\n", strings.Join(command, " "))
 		}
-		err := t.Base.RunCommand(command...)
+		err := t.runBaseCommand(ctx, command...)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,383 @@
+package cloudconfig_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/cloudconfigtest"
+	"melato.org/cloudconfig/ostype"
+	"melato.org/cloudconfig/plan"
+)
+
+func TestApply(t *testing.T) {
+	config, err := cloudconfig.Unmarshal([]byte(`#cloud-config
+packages: [curl]
+write_files:
+- path: /etc/motd
+  content: hello
+- path: /etc/motd
+  content: " world"
+  append: true
+- path: /home/a/bin/run
+  content: "#!/bin/sh"
+  permissions: "0755"
+  owner: a:a
+  defer: true
+users:
+- name: a
+  groups: wheel
+  sudo: true
+  ssh_authorized_keys: [ssh-ed25519 AAAA a@b]
+timezone: UTC
+runcmd:
+- [echo, a]
+- echo b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := cloudconfigtest.NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = &ostype.Alpine{}
+	err = configurer.Apply(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.AssertFile(t, "/etc/motd", "hello world", 0644)
+	base.AssertFile(t, "/home/a/bin/run", "#!/bin/sh", 0755)
+	base.AssertOwner(t, "/home/a/bin/run", "a:a")
+	base.AssertFile(t, "/home/a/.ssh/authorized_keys", "ssh-ed25519 AAAA a@b\n", 0600)
+	base.AssertOwner(t, "/home/a/.ssh", "a:a")
+	base.AssertRan(t, "adduser", "a", "wheel")
+	base.AssertRan(t, "setup-timezone", "-z", "UTC")
+	base.AssertRan(t, "echo", "a")
+	base.AssertScript(t, "apk add curl")
+	base.AssertScript(t, "chpasswd")
+	base.AssertScript(t, "/etc/sudoers.d")
+	base.AssertScript(t, "echo b")
+
+	var sections []string
+	for _, op := range base.Operations {
+		if op.Section != "" {
+			sections = append(sections, op.Section)
+		}
+	}
+	expected := "write_files packages users timezone write_files_deferred runcmd"
+	if strings.Join(sections, " ") != expected {
+		t.Fatalf("%v", sections)
+	}
+}
+
+func TestApplyExistingAuthorizedKeys(t *testing.T) {
+	base := cloudconfigtest.NewBaseConfigurer()
+	base.AddFile("/root/.ssh/authorized_keys", []byte("old\n"), 0600)
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = &ostype.Debian{}
+	err := configurer.Apply(&cloudconfig.Config{Users: []*cloudconfig.User{
+		{Name: "root", SshAuthorizedKeys: []string{"new"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.AssertFile(t, "/root/.ssh/authorized_keys", "old\n", 0600)
+}
+
+func TestApplyErrors(t *testing.T) {
+	base := cloudconfigtest.NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	err := configurer.Apply(&cloudconfig.Config{Packages: []string{"a"}})
+	if err == nil {
+		t.Fatalf("packages require an OS")
+	}
+	configurer.OS = &ostype.Debian{}
+	failure := errors.New("failed")
+	base.SetResult("echo a", "", failure)
+	err = configurer.Apply(&cloudconfig.Config{Runcmd: cloudconfig.Commands{
+		[]string{"echo", "a"},
+		[]string{"echo", "b"},
+	}})
+	if !errors.Is(err, failure) {
+		t.Fatalf("%v", err)
+	}
+	if strings.Join(base.Commands(), ",") != "echo a" {
+		t.Fatalf("%v", base.Commands())
+	}
+}
+
+func TestApplyContext(t *testing.T) {
+	base := cloudconfigtest.NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := configurer.ApplyContext(ctx, &cloudconfig.Config{Runcmd: cloudconfig.Commands{"echo a"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("%v", err)
+	}
+	if len(base.Operations) != 1 || base.Operations[0].Section != cloudconfig.SectionRuncmd {
+		t.Fatalf("%v", base.Operations)
+	}
+}
+
+func TestApplyCommandMap(t *testing.T) {
+	base := cloudconfigtest.NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	err := configurer.Apply(&cloudconfig.Config{Runcmd: cloudconfig.Commands{
+		map[string]any{"cmd": []any{"id"}, "user": "deploy"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.AssertScript(t, "su -s /bin/sh deploy -c id")
+}
+
+type exitError int
+
+func (t exitError) Error() string { return fmt.Sprintf("exit status %d", int(t)) }
+func (t exitError) ExitCode() int { return int(t) }
+
+func TestApplyReport(t *testing.T) {
+	base := cloudconfigtest.NewBaseConfigurer()
+	base.SetResult("make install", "line 1\nmissing target\n", exitError(2))
+	configurer := cloudconfig.NewConfigurer(base)
+	var output strings.Builder
+	configurer.Report = &cloudconfig.Report{Output: &output, TailSize: 15}
+	err := configurer.Apply(&cloudconfig.Config{
+		Files: []*cloudconfig.File{{Path: "/etc/a", Content: "a"}},
+		Runcmd: cloudconfig.Commands{
+			[]string{"echo", "a"},
+			[]string{"make", "install"},
+			[]string{"echo", "b"},
+		},
+	})
+	var stepError *cloudconfig.StepError
+	if !errors.As(err, &stepError) {
+		t.Fatalf("%v", err)
+	}
+	if err.Error() != "runcmd[1] make install: exit status 2" {
+		t.Fatalf("%s", err.Error())
+	}
+	steps := configurer.Report.Steps
+	var commands []string
+	for _, step := range steps {
+		commands = append(commands, step.String())
+	}
+	expected := "write_files[0] mkdir -p /etc,write_files[1] write /etc/a,runcmd[0] echo a,runcmd[1] make install"
+	if strings.Join(commands, ",") != expected {
+		t.Fatalf("%v", commands)
+	}
+	failed := configurer.Report.Failed()
+	if len(failed) != 1 || failed[0].ExitCode != 2 || failed[0].Output != "missing target\n" {
+		t.Fatalf("%v", failed)
+	}
+	if output.String() != "line 1\nmissing target\n" {
+		t.Fatalf("%q", output.String())
+	}
+	if base.Log != &output {
+		t.Fatalf("the base should write to the report output after Apply")
+	}
+	var summary strings.Builder
+	configurer.Report.WriteSummary(&summary)
+	if !strings.Contains(summary.String(), "FAIL runcmd[1] make install") ||
+		!strings.HasSuffix(summary.String(), "4 steps, 1 failed\n") {
+		t.Fatalf("%s", summary.String())
+	}
+}
+
+func TestApplyErrorPolicy(t *testing.T) {
+	install := "DEBIAN_FRONTEND=noninteractive apt-get -y install "
+	failure := errors.New("failed")
+	cases := []struct {
+		policy   cloudconfig.ErrorPolicy
+		ran      string
+		failures int
+	}{
+		{cloudconfig.FailFast, "a", 1},
+		{cloudconfig.ContinueSection, "a,b", 1},
+		{cloudconfig.ContinueAll, "a,b,echo a,echo b", 2},
+	}
+	for _, c := range cases {
+		base := cloudconfigtest.NewBaseConfigurer()
+		base.SetResult(install+"a", "", failure)
+		base.SetResult("echo a", "", failure)
+		configurer := cloudconfig.NewConfigurer(base)
+		configurer.OS = &ostype.Debian{}
+		configurer.OnError = c.policy
+		err := configurer.Apply(&cloudconfig.Config{
+			Packages: []string{"a", "b"},
+			Runcmd:   cloudconfig.Commands{"echo a", "echo b"},
+		})
+		var ran []string
+		for _, op := range base.Operations {
+			if op.Type == plan.OpScript {
+				ran = append(ran, strings.TrimPrefix(op.Script, install))
+			}
+		}
+		if strings.Join(ran, ",") != c.ran {
+			t.Errorf("%v: %v", c.policy, ran)
+		}
+		if !errors.Is(err, failure) {
+			t.Fatalf("%v: %v", c.policy, err)
+		}
+		failures := 1
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			failures = len(joined.Unwrap())
+		}
+		if failures != c.failures {
+			t.Errorf("%v: %d failures: %v", c.policy, failures, err)
+		}
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	for _, policy := range []cloudconfig.ErrorPolicy{cloudconfig.FailFast, cloudconfig.ContinueSection, cloudconfig.ContinueAll} {
+		parsed, err := cloudconfig.ParseErrorPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Errorf("%v: %v %v", policy, parsed, err)
+		}
+	}
+	_, err := cloudconfig.ParseErrorPolicy("ignore")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestApplyRetry(t *testing.T) {
+	install := "apk add a"
+	failure := errors.New("failed")
+	base := cloudconfigtest.NewBaseConfigurer()
+	base.Results[install] = cloudconfigtest.Result{Output: "lock\n", Err: failure, Times: 2}
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = &ostype.Alpine{}
+	configurer.WaitPackageLock = true
+	configurer.PackageRetry = cloudconfig.RetryPolicy{Attempts: 3, Delay: time.Millisecond}
+	configurer.Report = &cloudconfig.Report{}
+	err := configurer.Apply(&cloudconfig.Config{Packages: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var installs, waits int
+	for _, op := range base.Operations {
+		switch {
+		case op.Script == install:
+			installs++
+		case strings.Contains(op.Script, "fuser"):
+			waits++
+		}
+	}
+	if installs != 3 || waits != 3 {
+		t.Fatalf("installs: %d, waits: %d", installs, waits)
+	}
+	steps := configurer.Report.Steps
+	if len(steps) != 6 || !steps[1].Retried || steps[1].Index != 1 || steps[5].Retried || steps[5].Index != 1 {
+		t.Fatalf("%v", steps)
+	}
+	if len(configurer.Report.Failed()) != 0 {
+		t.Fatalf("%v", configurer.Report.Failed())
+	}
+
+	base = cloudconfigtest.NewBaseConfigurer()
+	configurer = cloudconfig.NewConfigurer(base)
+	configurer.OS = &ostype.Debian{}
+	configurer.WaitPackageLock = true
+	err = configurer.Apply(&cloudconfig.Config{Packages: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(base.Operations) != 2 || !strings.Contains(base.Operations[1].Script, "apt-get -o DPkg::Lock::Timeout=300 -y install a") {
+		t.Fatalf("%v", base.Operations)
+	}
+
+	base = cloudconfigtest.NewBaseConfigurer()
+	base.SetResult("false", "", failure)
+	configurer = cloudconfig.NewConfigurer(base)
+	configurer.CommandRetry = cloudconfig.RetryPolicy{Attempts: 2}
+	configurer.OnError = cloudconfig.ContinueAll
+	err = configurer.Apply(&cloudconfig.Config{Runcmd: cloudconfig.Commands{"false", "true"}})
+	if !errors.Is(err, failure) {
+		t.Fatalf("%v", err)
+	}
+	if len(base.Operations) != 4 || base.Operations[3].Script != "true" {
+		t.Fatalf("%v", base.Operations)
+	}
+}
+
+func TestApplyInvalidEntries(t *testing.T) {
+	base := cloudconfigtest.NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OnError = cloudconfig.ContinueSection
+	configurer.CommandRetry = cloudconfig.RetryPolicy{Attempts: 3}
+	configurer.Report = &cloudconfig.Report{}
+	err := configurer.Apply(&cloudconfig.Config{
+		Files: []*cloudconfig.File{
+			{Path: "/etc/a", Permissions: "x", Content: "a"},
+			{Path: "/etc/b", Content: "b"},
+		},
+		Runcmd: cloudconfig.Commands{[]any{}, 1, map[string]any{"user": "a"}, "echo a"},
+	})
+	var stepError *cloudconfig.StepError
+	if !errors.As(err, &stepError) || stepError.Step.String() != "write_files[0] write /etc/a" {
+		t.Fatalf("%v", err)
+	}
+	base.AssertFile(t, "/etc/b", "b", 0644)
+	if len(base.Commands()) != 1 {
+		t.Fatalf("the runcmd section should not run after write_files failed: %v", base.Commands())
+	}
+
+	base = cloudconfigtest.NewBaseConfigurer()
+	configurer = cloudconfig.NewConfigurer(base)
+	configurer.OnError = cloudconfig.ContinueSection
+	configurer.CommandRetry = cloudconfig.RetryPolicy{Attempts: 3}
+	configurer.Report = &cloudconfig.Report{}
+	err = configurer.Apply(&cloudconfig.Config{
+		Runcmd: cloudconfig.Commands{[]any{}, 1, map[string]any{"user": "a"}, "echo a"},
+	})
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	expected := "runcmd[0] []: empty command,runcmd[1] 1: invalid command type: int,runcmd[2] map[user:a]: missing cmd"
+	if strings.Join(messages, ",") != expected {
+		t.Fatalf("%v", messages)
+	}
+	base.AssertScript(t, "echo a")
+	if len(configurer.Report.Steps) != 4 || configurer.Report.Steps[3].Index != 3 {
+		t.Fatalf("invalid entries should not be retried: %v", configurer.Report.Steps)
+	}
+}
+
+type testFetcher map[string]string
+
+func (t testFetcher) Fetch(url string) ([]byte, error) {
+	s, exists := t[url]
+	if !exists {
+		return nil, fmt.Errorf("not found: %s", url)
+	}
+	return []byte(s), nil
+}
+
+func TestApplyConfigFilesInclude(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "user-data")
+	err := os.WriteFile(file, []byte("#include\nhttp://x/a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := cloudconfigtest.NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = &ostype.Debian{}
+	configurer.Fetcher = testFetcher{
+		"http://x/a": "#cloud-config\nwrite_files:\n- path: /etc/a\n  content: a\n",
+	}
+	err = configurer.ApplyConfigFiles(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.AssertFile(t, "/etc/a", "a", 0644)
+}
@@ -0,0 +1,211 @@
+// Package dockerfile converts a cloud-config to a Containerfile/Dockerfile and its build context.
+package dockerfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/shell"
+)
+
+// Syntax is the Dockerfile syntax directive.
+// The generated Dockerfile uses heredocs and COPY --chmod, which require BuildKit.
+const Syntax = "docker/dockerfile:1"
+
+// FilesDir is the directory of the build context that contains the files of write_files.
+const FilesDir = "files"
+
+// TmpDir is where appended content is copied, before it is appended.
+const TmpDir = "/tmp/cloudconfig"
+
+// Context is a generated Dockerfile and its build context.
+type Context struct {
+	Dockerfile []byte
+	// Files maps build context paths to their content.
+	Files map[string][]byte
+}
+
+type copyInstruction struct {
+	src   string
+	dest  string
+	perm  fs.FileMode
+	owner string
+}
+
+// BaseConfigurer writes Dockerfile instructions, instead of applying a config:
+//   - The commands of the packages section are combined in one RUN instruction.
+//   - Files are copied from the build context, with their permissions and owner.
+//   - Appended content is copied to TmpDir and appended with RUN.
+//   - Other commands and scripts become RUN instructions.
+//
+// FileExists always returns false.
+type BaseConfigurer struct {
+	Log      io.Writer
+	OS       cloudconfig.OSType
+	buf      bytes.Buffer
+	files    map[string][]byte
+	section  string
+	packages []string
+	copy     *copyInstruction
+}
+
+// NewBaseConfigurer creates a BaseConfigurer for an image that starts from the given base image.
+func NewBaseConfigurer(from string, os cloudconfig.OSType) *BaseConfigurer {
+	t := &BaseConfigurer{OS: os, files: make(map[string][]byte)}
+	fmt.Fprintf(&t.buf, "# syntax=%s\n# generated by cloudconfig\nFROM %s\n", Syntax, from)
+	return t
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+func (t *BaseConfigurer) flush() {
+	if t.copy != nil {
+		c := t.copy
+		t.copy = nil
+		fmt.Fprintf(&t.buf, "COPY --chmod=%04o", c.perm.Perm())
+		if c.owner != "" {
+			fmt.Fprintf(&t.buf, " --chown=%s", c.owner)
+		}
+		fmt.Fprintf(&t.buf, " %s\n", copyArgs(c.src, c.dest))
+	}
+	if len(t.packages) > 0 {
+		commands := t.packages
+		t.packages = nil
+		update, ok := t.OS.(cloudconfig.OSUpdatePackages)
+		if ok {
+			commands = append([]string{update.UpdatePackagesCommand()}, commands...)
+		}
+		fmt.Fprintf(&t.buf, "RUN %s\n", strings.Join(commands, " \\\n && "))
+	}
+}
+
+func (t *BaseConfigurer) BeginSection(name string) {
+	t.flush()
+	t.section = name
+	fmt.Fprintf(&t.buf, "\n# %s\n", name)
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	if t.section == cloudconfig.SectionPackages {
+		t.packages = append(t.packages, strings.TrimSpace(script))
+		return nil
+	}
+	t.flush()
+	script = strings.TrimSuffix(script, "\n")
+	if !strings.Contains(script, "\n") {
+		fmt.Fprintf(&t.buf, "RUN %s\n", script)
+		return nil
+	}
+	d := shell.HeredocDelimiter(script)
+	fmt.Fprintf(&t.buf, "RUN <<'%s'\n%s\n%s\n", d, script, d)
+	return nil
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	if t.copy != nil && len(args) == 3 && args[0] == "chown" && args[2] == t.copy.dest {
+		t.copy.owner = args[1]
+		return nil
+	}
+	if t.section == cloudconfig.SectionWriteFiles || t.section == cloudconfig.SectionDeferredFiles {
+		if len(args) == 3 && args[0] == "mkdir" && args[1] == "-p" {
+			// COPY creates directories
+			return nil
+		}
+	}
+	t.flush()
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&t.buf, "RUN %s\n", string(data))
+	return nil
+}
+
+// copyArgs returns the source and destination of a COPY instruction in the JSON array form,
+// which allows spaces in paths.
+func copyArgs(src, dest string) string {
+	data, _ := json.Marshal([]string{src, dest})
+	return string(data)
+}
+
+// addFile adds a file to the build context and returns its context path.
+func (t *BaseConfigurer) addFile(dest string, data []byte) string {
+	src := path.Join(FilesDir, fmt.Sprintf("%03d-%s", len(t.files)+1, path.Base(dest)))
+	t.files[src] = data
+	return src
+}
+
+func (t *BaseConfigurer) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	t.flush()
+	t.copy = &copyInstruction{src: t.addFile(path, data), dest: path, perm: perm}
+	return nil
+}
+
+func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode) error {
+	t.flush()
+	src := t.addFile(path, data)
+	tmp := TmpDir + "/" + filepath.Base(src)
+	fmt.Fprintf(&t.buf, "COPY %s\n", copyArgs(src, tmp))
+	dest := cloudconfig.ShellQuote(path)
+	tmp = cloudconfig.ShellQuote(tmp)
+	fmt.Fprintf(&t.buf, "RUN if [ ! -e %s ]; then install -m %04o /dev/null %s; fi && cat %s >> %s && rm %s\n",
+		dest, perm.Perm(), dest, tmp, dest, tmp)
+	return nil
+}
+
+func (t *BaseConfigurer) FileExists(path string) (bool, error) {
+	return false, nil
+}
+
+// Context returns the generated Dockerfile and build context.
+func (t *BaseConfigurer) Context() *Context {
+	t.flush()
+	return &Context{Dockerfile: append([]byte(nil), t.buf.Bytes()...), Files: t.files}
+}
+
+// Export converts user-data to a Dockerfile and build context.
+func Export(u *cloudconfig.UserData, os cloudconfig.OSType, from string) (*Context, error) {
+	base := NewBaseConfigurer(from, os)
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = os
+	err := configurer.ApplyUserData(u)
+	if err != nil {
+		return nil, err
+	}
+	return base.Context(), nil
+}
+
+// WriteDir writes the Dockerfile and the build context files to a directory.
+func (t *Context) WriteDir(dir string) error {
+	paths := make([]string, 0, len(t.Files))
+	for p := range t.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		file := filepath.Join(dir, filepath.FromSlash(p))
+		err := os.MkdirAll(filepath.Dir(file), fs.FileMode(0775))
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(file, t.Files[p], fs.FileMode(0644))
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, "Dockerfile"), t.Dockerfile, fs.FileMode(0644))
+}
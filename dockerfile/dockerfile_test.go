@@ -0,0 +1,79 @@
+package dockerfile
+
+import (
+	"strings"
+	"testing"
+
+	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/ostype"
+)
+
+func TestInterfaces(t *testing.T) {
+	var _ cloudconfig.BaseConfigurer = &BaseConfigurer{}
+	var _ cloudconfig.BaseSection = &BaseConfigurer{}
+}
+
+func TestExport(t *testing.T) {
+	config, err := cloudconfig.Unmarshal([]byte(`#cloud-config
+packages: [curl, git]
+write_files:
+- path: /etc/a
+  permissions: '0600'
+  content: a
+- path: /home/deploy/b
+  owner: deploy:deploy
+  content: b
+  defer: true
+users:
+- name: deploy
+runcmd:
+- echo done
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Export(&cloudconfig.UserData{Config: config}, &ostype.Debian{}, "debian:12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(c.Dockerfile)
+	expected := []string{
+		"FROM debian:12\n",
+		`COPY --chmod=0600 ["files/001-a","/etc/a"]` + "\n",
+		"RUN DEBIAN_FRONTEND=noninteractive apt-get update \\\n && DEBIAN_FRONTEND=noninteractive apt-get -y install curl \\\n && DEBIAN_FRONTEND=noninteractive apt-get -y install git\n",
+		`RUN ["adduser","deploy","--disabled-password","--gecos",""]`,
+		`COPY --chmod=0644 --chown=deploy:deploy ["files/002-b","/home/deploy/b"]` + "\n",
+		"RUN echo done\n",
+	}
+	last := 0
+	for _, e := range expected {
+		i := strings.Index(s, e)
+		if i < last {
+			t.Fatalf("missing or out of order: %q\n%s", e, s)
+		}
+		last = i
+	}
+	if string(c.Files["files/002-b"]) != "b" {
+		t.Fatalf("%v", c.Files)
+	}
+}
+
+func TestExportQuoting(t *testing.T) {
+	base := NewBaseConfigurer("alpine", nil)
+	base.WriteFile("/srv/my app/a b", []byte("a"), 0644)
+	base.AppendFile("/srv/my app/a b", []byte("b"), 0644)
+	base.RunScript("cat <<'CLOUDCONFIG_EOF'\na\nCLOUDCONFIG_EOF\n")
+	s := string(base.Context().Dockerfile)
+	expected := []string{
+		`COPY --chmod=0644 ["files/001-a b","/srv/my app/a b"]` + "\n",
+		`COPY ["files/002-a b","/tmp/cloudconfig/002-a b"]` + "\n",
+		`RUN if [ ! -e '/srv/my app/a b' ]; then install -m 0644 /dev/null '/srv/my app/a b'; fi && ` +
+			`cat '/tmp/cloudconfig/002-a b' >> '/srv/my app/a b' && rm '/tmp/cloudconfig/002-a b'` + "\n",
+		"RUN <<'CLOUDCONFIG_EOF1'\ncat <<'CLOUDCONFIG_EOF'\na\nCLOUDCONFIG_EOF\nCLOUDCONFIG_EOF1\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(s, e) {
+			t.Fatalf("missing %q\n%s", e, s)
+		}
+	}
+}
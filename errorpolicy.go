@@ -0,0 +1,102 @@
+package cloudconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorPolicy specifies what Configurer does when a step fails.
+type ErrorPolicy int
+
+const (
+	// FailFast stops at the first failed step.
+	FailFast ErrorPolicy = iota
+	// ContinueSection runs the remaining steps of the section of a failed step, and then stops.
+	ContinueSection
+	// ContinueAll runs all the steps, as cloud-init keeps running later modules.
+	ContinueAll
+)
+
+// The names of the error policies, for ParseErrorPolicy
+const (
+	ErrorPolicyFail     = "fail"
+	ErrorPolicySection  = "section"
+	ErrorPolicyContinue = "continue"
+)
+
+// ParseErrorPolicy parses an ErrorPolicy from its name: fail, section, or continue.
+// The empty string is FailFast.
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	switch name {
+	case "", ErrorPolicyFail:
+		return FailFast, nil
+	case ErrorPolicySection:
+		return ContinueSection, nil
+	case ErrorPolicyContinue:
+		return ContinueAll, nil
+	default:
+		return FailFast, fmt.Errorf("unrecognized error policy: %s.  accepted values are fail, section, continue", name)
+	}
+}
+
+func (t ErrorPolicy) String() string {
+	switch t {
+	case ContinueSection:
+		return ErrorPolicySection
+	case ContinueAll:
+		return ErrorPolicyContinue
+	default:
+		return ErrorPolicyFail
+	}
+}
+
+// collect runs apply with the OnError policy.
+// It returns the errors of all the failed steps, joined with errors.Join, or the error itself, if there is only one.
+// It also ends reporting, when apply is done.
+func (t *Configurer) collect(apply func() error) error {
+	if t.continuing {
+		return apply()
+	}
+	defer t.endReporting()
+	if t.OnError == FailFast {
+		return apply()
+	}
+	t.continuing = true
+	t.failures = nil
+	err := apply()
+	errs := t.failures
+	if err != nil {
+		errs = append(errs, err)
+	}
+	t.continuing = false
+	t.failures = nil
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// failed records the error of a failed step.
+// It returns false if the apply should stop.
+func (t *Configurer) failed(err error) bool {
+	if !t.continuing {
+		return false
+	}
+	t.failures = append(t.failures, err)
+	return true
+}
+
+// endSection is called after a section, with the error that stopped it.
+// It returns an error if the apply should stop.
+func (t *Configurer) endSection(ctx context.Context, err error) error {
+	if err != nil && (ctx.Err() != nil || t.OnError != ContinueAll || !t.failed(err)) {
+		return err
+	}
+	if t.continuing && t.OnError == ContinueSection && len(t.failures) > 0 {
+		errs := t.failures
+		t.failures = nil
+		return errors.Join(errs...)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package execprefix provides a BaseConfigurer that runs everything through a command prefix,
+// such as "podman exec -i mybox", "docker exec -i mybox", or "systemd-nspawn -D /var/lib/machines/mybox".
+package execprefix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"strings"
+
+	"melato.org/cloudconfig"
+)
+
+// writeScript creates a file with mode $2, if it does not exist, and copies stdin to it.
+// $1 is the file, and $3 is the redirection operator (> or >>).
+const writeScript = `if [ ! -e "$1" ]; then : > "$1"; chmod "$2" "$1"; fi
+if [ "$3" = ">>" ]; then cat >> "$1"; else cat > "$1"; fi`
+
+// BaseConfigurer runs commands by appending them to Prefix.
+// The prefix must pass stdin to the command, as "docker exec -i" does.
+// Scripts are piped to sh, and files are written with sh and cat,
+// so the target needs sh and cat.
+type BaseConfigurer struct {
+	Prefix []string
+	Log    io.Writer
+}
+
+// NewBaseConfigurer creates a BaseConfigurer with a command prefix.
+func NewBaseConfigurer(prefix ...string) *BaseConfigurer {
+	return &BaseConfigurer{Prefix: prefix}
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+// command creates a command that runs args through the prefix.
+func (t *BaseConfigurer) command(args ...string) (*exec.Cmd, error) {
+	return t.commandContext(context.Background(), args...)
+}
+
+// commandContext creates a command that runs args through the prefix, and is killed when ctx is done.
+// Killing the prefix command may not stop the command in the container.
+func (t *BaseConfigurer) commandContext(ctx context.Context, args ...string) (*exec.Cmd, error) {
+	if len(t.Prefix) == 0 {
+		return nil, fmt.Errorf("missing command prefix")
+	}
+	all := append(append([]string(nil), t.Prefix...), args...)
+	cmd := exec.CommandContext(ctx, all[0], all[1:]...)
+	cmd.Stdout = t.Log
+	cmd.Stderr = t.Log
+	return cmd, nil
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	return t.RunCommandContext(context.Background(), args...)
+}
+
+func (t *BaseConfigurer) RunCommandContext(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	cmd, err := t.commandContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return cmd.Run()
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	return t.RunScriptContext(context.Background(), script)
+}
+
+func (t *BaseConfigurer) RunScriptContext(ctx context.Context, script string) error {
+	cmd, err := t.commandContext(ctx, "sh")
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = strings.NewReader(script)
+	return cmd.Run()
+}
+
+func (t *BaseConfigurer) write(path string, data []byte, perm fs.FileMode, redirect string) error {
+	cmd, err := t.command("sh", "-c", writeScript, "sh", path, fmt.Sprintf("%04o", perm.Perm()), redirect)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	return cmd.Run()
+}
+
+func (t *BaseConfigurer) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	return t.write(path, data, perm, ">")
+}
+
+func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode) error {
+	return t.write(path, data, perm, ">>")
+}
+
+func (t *BaseConfigurer) FileExists(path string) (bool, error) {
+	cmd, err := t.command("test", "-e", path)
+	if err != nil {
+		return false, err
+	}
+	err = cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	var exitError *exec.ExitError
+	if !errors.As(err, &exitError) || exitError.ExitCode() != 1 {
+		return false, err
+	}
+	// The prefix may also exit with 1, as sudo -n does when it needs a password,
+	// so check that the prefix can run a command.
+	cmd, err = t.command("true")
+	if err != nil {
+		return false, err
+	}
+	err = cmd.Run()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", strings.Join(t.Prefix, " "), err)
+	}
+	return false, nil
+}
+
+// UserHomeDir looks up a user's home directory in the /etc/passwd of the target.
+func (t *BaseConfigurer) UserHomeDir(username string) (string, error) {
+	var buf bytes.Buffer
+	cmd, err := t.command("cat", "/etc/passwd")
+	if err != nil {
+		return "", err
+	}
+	cmd.Stdout = &buf
+	err = cmd.Run()
+	if err != nil {
+		return "", err
+	}
+	return cloudconfig.PasswdHomeDir(&buf, username)
+}
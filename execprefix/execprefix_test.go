@@ -0,0 +1,67 @@
+package execprefix
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/cloudconfigtest"
+)
+
+func TestInterfaces(t *testing.T) {
+	base := &BaseConfigurer{}
+	var _ cloudconfig.BaseConfigurer = base
+	var _ cloudconfig.BaseUserHomeDir = base
+	var _ cloudconfig.BaseContext = base
+}
+
+func TestEnv(t *testing.T) {
+	base := NewBaseConfigurer("env", "CLOUDCONFIG_TEST=a")
+	var log strings.Builder
+	base.SetLogWriter(&log)
+	file := filepath.Join(t.TempDir(), "a b")
+	exists, err := base.FileExists(file)
+	if err != nil || exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+	err = base.WriteFile(file, []byte("a\n"), 0600)
+	if err == nil {
+		err = base.AppendFile(file, []byte("$b\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "a\n$b\n" {
+		t.Fatalf("%v %q", err, string(data))
+	}
+	st, err := os.Stat(file)
+	if err != nil || st.Mode().Perm() != 0600 {
+		t.Fatalf("%v %v", err, st.Mode())
+	}
+	exists, err = base.FileExists(file)
+	if err != nil || !exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+	err = base.RunCommand("echo", "it's")
+	if err == nil {
+		err = base.RunScript("echo $CLOUDCONFIG_TEST\n")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.String() != "it's\na\n" {
+		t.Fatalf("%q", log.String())
+	}
+	if base.RunCommand("false") == nil {
+		t.Fatalf("false should fail")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	cloudconfigtest.RunConformance(t, func(t *testing.T) (cloudconfig.BaseConfigurer, string) {
+		return NewBaseConfigurer("env"), t.TempDir()
+	})
+}
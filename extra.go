@@ -0,0 +1,153 @@
+package cloudconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// encoding/json does not support inline maps,
+// so Config, File and User implement json.Marshaler and json.Unmarshaler
+// in order to preserve their Extra keys.
+
+type jsonConfig Config
+type jsonFile File
+type jsonUser User
+
+func (t *Config) MarshalJSON() ([]byte, error) {
+	c := *t
+	c.Runcmd = NormalizeYaml([]any(t.Runcmd)).([]any)
+	c.MergeHow = NormalizeYaml(t.MergeHow)
+	c.MergeType = NormalizeYaml(t.MergeType)
+	return marshalJSONExtra((*jsonConfig)(&c), t.Extra)
+}
+
+func (t *Config) UnmarshalJSON(data []byte) error {
+	return unmarshalJSONExtra(data, (*jsonConfig)(t), &t.Extra)
+}
+
+func (t *File) MarshalJSON() ([]byte, error) {
+	return marshalJSONExtra((*jsonFile)(t), t.Extra)
+}
+
+func (t *File) UnmarshalJSON(data []byte) error {
+	// A JSON number is the mode itself, as in cloud-init, so convert it to octal.
+	data, err := numbersToStrings(data, "permissions", func(n int64) string { return fmt.Sprintf("%#o", n) })
+	if err != nil {
+		return err
+	}
+	return unmarshalJSONExtra(data, (*jsonFile)(t), &t.Extra)
+}
+
+func (t *User) MarshalJSON() ([]byte, error) {
+	u := *t
+	u.Sudo = NormalizeYaml(t.Sudo)
+	return marshalJSONExtra((*jsonUser)(&u), t.Extra)
+}
+
+func (t *User) UnmarshalJSON(data []byte) error {
+	data, err := numbersToStrings(data, "uid", func(n int64) string { return strconv.FormatInt(n, 10) })
+	if err != nil {
+		return err
+	}
+	return unmarshalJSONExtra(data, (*jsonUser)(t), &t.Extra)
+}
+
+// marshalJSONExtra marshals v, which must be a pointer to a struct,
+// and appends the extra keys to the resulting JSON object.
+func marshalJSONExtra(v any, extra map[string]any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	extraData, err := json.Marshal(NormalizeYaml(extra))
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "{}" {
+		return extraData, nil
+	}
+	result := make([]byte, 0, len(data)+len(extraData))
+	result = append(result, data[:len(data)-1]...)
+	result = append(result, ',')
+	result = append(result, extraData[1:]...)
+	return result, nil
+}
+
+// unmarshalJSONExtra unmarshals data into v, which must be a pointer to a struct,
+// and puts any keys that do not correspond to fields of v in extra.
+// Keys are compared case-insensitively, as encoding/json does.
+func unmarshalJSONExtra(data []byte, v any, extra *map[string]any) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+	var m map[string]any
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return err
+	}
+	keys := jsonKeys(reflect.TypeOf(v).Elem())
+	for key := range m {
+		for _, known := range keys {
+			if strings.EqualFold(key, known) {
+				delete(m, key)
+				break
+			}
+		}
+	}
+	if len(m) > 0 {
+		*extra = m
+	} else {
+		*extra = nil
+	}
+	return nil
+}
+
+// numbersToStrings converts the integer value of key in the JSON object data to a string, using format.
+// It allows string fields, such as uid and permissions, to be unquoted integers in JSON, as they can be in YAML.
+func numbersToStrings(data []byte, key string, format func(int64) string) ([]byte, error) {
+	var m map[string]json.RawMessage
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	for k, value := range m {
+		if !strings.EqualFold(k, key) {
+			continue
+		}
+		var n int64
+		if string(value) == "null" || json.Unmarshal(value, &n) != nil {
+			continue
+		}
+		m[k], err = json.Marshal(format(n))
+		if err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(m)
+}
+
+// jsonKeys returns the json keys of the fields of a struct type
+func jsonKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
@@ -0,0 +1,230 @@
+// Package extract provides a BaseConfigurer that renders files into a tar archive or a directory tree,
+// instead of the live system.
+package extract
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a file or directory in the rendered tree.
+type Entry struct {
+	// Path is the absolute, clean path of the entry
+	Path  string
+	Dir   bool
+	Mode  fs.FileMode
+	Owner string
+	Group string
+	Data  []byte
+}
+
+// BaseConfigurer records files in memory, so they can be written to a tar archive or a directory.
+// It interprets the commands that Configurer runs for files (mkdir -p, chmod, chown).
+// Other commands and scripts fail, unless SkipCommands is true.
+type BaseConfigurer struct {
+	Log io.Writer
+	// SkipCommands skips commands and scripts that are not file operations, instead of failing.
+	SkipCommands bool
+	// ModTime is the modification time of the entries.  If it is zero, the current time is used.
+	ModTime time.Time
+	entries map[string]*Entry
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+func (t *BaseConfigurer) logf(format string, args ...any) {
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, format, args...)
+	}
+}
+
+func (t *BaseConfigurer) entry(p string) *Entry {
+	return t.entries[path.Clean("/"+p)]
+}
+
+func (t *BaseConfigurer) add(e *Entry) {
+	if t.entries == nil {
+		t.entries = make(map[string]*Entry)
+	}
+	e.Path = path.Clean("/" + e.Path)
+	t.entries[e.Path] = e
+}
+
+func (t *BaseConfigurer) mkdirAll(dir string) {
+	for d := path.Clean("/" + dir); d != "/"; d = path.Dir(d) {
+		if t.entry(d) == nil {
+			t.add(&Entry{Path: d, Dir: true, Mode: fs.FileMode(0755)})
+		}
+	}
+}
+
+func (t *BaseConfigurer) skip(what string) error {
+	if t.SkipCommands {
+		t.logf("skip %s\n", what)
+		return nil
+	}
+	return fmt.Errorf("cannot run commands when extracting files: %s", what)
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	line, _, _ := strings.Cut(strings.TrimSpace(script), "\n")
+	return t.skip("script: " + line)
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	switch {
+	case len(args) == 3 && args[0] == "mkdir" && args[1] == "-p":
+		t.mkdirAll(args[2])
+		return nil
+	case len(args) == 3 && args[0] == "chmod":
+		e := t.entry(args[2])
+		mode, err := strconv.ParseUint(args[1], 8, 32)
+		if e != nil && err == nil {
+			e.Mode = fs.FileMode(mode)
+			return nil
+		}
+	case len(args) == 3 && args[0] == "chown":
+		e := t.entry(args[2])
+		if e != nil {
+			e.Owner, e.Group, _ = strings.Cut(args[1], ":")
+			return nil
+		}
+	}
+	return t.skip(strings.Join(args, " "))
+}
+
+func (t *BaseConfigurer) WriteFile(file string, data []byte, perm fs.FileMode) error {
+	e := t.entry(file)
+	if e != nil && e.Dir {
+		return fmt.Errorf("%s: is a directory", file)
+	}
+	if e == nil {
+		e = &Entry{Path: file, Mode: perm}
+		t.add(e)
+		t.mkdirAll(path.Dir(e.Path))
+	}
+	e.Data = append([]byte(nil), data...)
+	return nil
+}
+
+// AppendFile appends to an earlier entry, or creates a new entry.
+func (t *BaseConfigurer) AppendFile(file string, data []byte, perm fs.FileMode) error {
+	e := t.entry(file)
+	if e == nil {
+		return t.WriteFile(file, data, perm)
+	}
+	if e.Dir {
+		return fmt.Errorf("%s: is a directory", file)
+	}
+	e.Data = append(e.Data, data...)
+	return nil
+}
+
+func (t *BaseConfigurer) FileExists(file string) (bool, error) {
+	return t.entry(file) != nil, nil
+}
+
+// Entries returns the entries, sorted by path, so that directories precede their contents.
+func (t *BaseConfigurer) Entries() []*Entry {
+	entries := make([]*Entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+func (t *BaseConfigurer) modTime() time.Time {
+	if t.ModTime.IsZero() {
+		return time.Now()
+	}
+	return t.ModTime
+}
+
+// WriteTar writes the entries to a tar stream, with their mode and owner.
+// Numeric owners are stored as uid/gid, and others as user/group names.
+// Paths are relative to the root of the archive.
+func (t *BaseConfigurer) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	modTime := t.modTime()
+	for _, e := range t.Entries() {
+		h := &tar.Header{
+			Name:    strings.TrimPrefix(e.Path, "/"),
+			Mode:    int64(e.Mode.Perm()),
+			ModTime: modTime,
+			Format:  tar.FormatPAX,
+		}
+		if e.Dir {
+			h.Typeflag = tar.TypeDir
+			h.Name += "/"
+		} else {
+			h.Typeflag = tar.TypeReg
+			h.Size = int64(len(e.Data))
+		}
+		if id, err := strconv.Atoi(e.Owner); err == nil {
+			h.Uid = id
+		} else {
+			h.Uname = e.Owner
+		}
+		if id, err := strconv.Atoi(e.Group); err == nil {
+			h.Gid = id
+		} else {
+			h.Gname = e.Group
+		}
+		err := tw.WriteHeader(h)
+		if err != nil {
+			return err
+		}
+		if !e.Dir {
+			_, err = tw.Write(e.Data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// WriteDir writes the entries under a destination directory, with their mode.
+// Owners are not applied.
+func (t *BaseConfigurer) WriteDir(dir string) error {
+	for _, e := range t.Entries() {
+		file := filepath.Join(dir, filepath.FromSlash(e.Path))
+		if e.Dir {
+			err := os.MkdirAll(file, e.Mode.Perm())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(file), fs.FileMode(0755))
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(file, e.Data, e.Mode.Perm())
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(file, e.Mode.Perm())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
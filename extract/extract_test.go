@@ -0,0 +1,95 @@
+package extract
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"melato.org/cloudconfig"
+)
+
+func TestInterfaces(t *testing.T) {
+	var _ cloudconfig.BaseConfigurer = &BaseConfigurer{}
+}
+
+func testConfig() *cloudconfig.Config {
+	return &cloudconfig.Config{
+		Files: []*cloudconfig.File{
+			{Path: "/etc/a", Content: "a", Permissions: "0600", Owner: "root:adm"},
+			{Path: "/etc/a", Content: "b", Append: true},
+			{Path: "/etc/x/b", Content: "b", Owner: "1000:1000", Defer: true},
+		},
+	}
+}
+
+func TestTar(t *testing.T) {
+	base := &BaseConfigurer{}
+	err := cloudconfig.NewConfigurer(base).Apply(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = base.WriteTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := tar.NewReader(&buf)
+	var names []string
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		switch h.Name {
+		case "etc/a":
+			data, _ := io.ReadAll(r)
+			if string(data) != "ab" || h.Mode != 0600 || h.Uname != "root" || h.Gname != "adm" {
+				t.Fatalf("%v %s", h, string(data))
+			}
+		case "etc/x/b":
+			if h.Uid != 1000 || h.Gid != 1000 || h.Mode != 0644 {
+				t.Fatalf("%v", h)
+			}
+		}
+	}
+	if len(names) != 4 || names[0] != "etc/" {
+		t.Fatalf("%v", names)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	base := &BaseConfigurer{}
+	configurer := cloudconfig.NewConfigurer(base)
+	config := &cloudconfig.Config{Runcmd: cloudconfig.Commands{"echo a"}}
+	if configurer.Apply(config) == nil {
+		t.Fatalf("commands should fail")
+	}
+	base.SkipCommands = true
+	if err := configurer.Apply(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	base := &BaseConfigurer{}
+	err := cloudconfig.NewConfigurer(base).Apply(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = base.WriteDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "etc", "a"))
+	if err != nil || string(data) != "ab" {
+		t.Fatalf("%v %s", err, string(data))
+	}
+}
@@ -0,0 +1,323 @@
+// Package ignition converts a cloud-config to an Ignition v3 config, for Fedora CoreOS.
+package ignition
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"melato.org/cloudconfig"
+)
+
+// Version is the Ignition spec version of the generated configs
+const Version = "3.4.0"
+
+// Config is the subset of the Ignition v3 config that the converter produces.
+type Config struct {
+	Ignition Ignition `json:"ignition"`
+	Passwd   *Passwd  `json:"passwd,omitempty"`
+	Storage  *Storage `json:"storage,omitempty"`
+}
+
+type Ignition struct {
+	Version string `json:"version"`
+}
+
+type Passwd struct {
+	Users  []*User  `json:"users,omitempty"`
+	Groups []*Group `json:"groups,omitempty"`
+}
+
+type User struct {
+	Name              string   `json:"name"`
+	UID               *int     `json:"uid,omitempty"`
+	Gecos             string   `json:"gecos,omitempty"`
+	HomeDir           string   `json:"homeDir,omitempty"`
+	NoCreateHome      bool     `json:"noCreateHome,omitempty"`
+	PrimaryGroup      string   `json:"primaryGroup,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Shell             string   `json:"shell,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+}
+
+type Group struct {
+	Name string `json:"name"`
+}
+
+type Storage struct {
+	Files []*File `json:"files,omitempty"`
+	Links []*Link `json:"links,omitempty"`
+}
+
+type NodeUser struct {
+	Name string `json:"name,omitempty"`
+}
+
+type NodeGroup struct {
+	Name string `json:"name,omitempty"`
+}
+
+type Resource struct {
+	Source string `json:"source"`
+}
+
+type File struct {
+	Path      string     `json:"path"`
+	Overwrite *bool      `json:"overwrite,omitempty"`
+	Mode      *int       `json:"mode,omitempty"`
+	User      *NodeUser  `json:"user,omitempty"`
+	Group     *NodeGroup `json:"group,omitempty"`
+	Contents  *Resource  `json:"contents,omitempty"`
+	Append    []Resource `json:"append,omitempty"`
+}
+
+type Link struct {
+	Path      string `json:"path"`
+	Target    string `json:"target"`
+	Hard      bool   `json:"hard,omitempty"`
+	Overwrite *bool  `json:"overwrite,omitempty"`
+}
+
+// Unsupported is a part of the cloud-config that has no Ignition equivalent.
+type Unsupported struct {
+	Key    string
+	Reason string
+}
+
+func (t Unsupported) String() string {
+	return t.Key + ": " + t.Reason
+}
+
+// DataURL encodes content as an RFC 2397 data URL.
+func DataURL(content string) string {
+	return "data:," + url.PathEscape(content)
+}
+
+type converter struct {
+	config      *Config
+	files       map[string]*File
+	unsupported []Unsupported
+}
+
+func (t *converter) unsupportedf(key string, format string, args ...any) {
+	t.unsupported = append(t.unsupported, Unsupported{Key: key, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Convert converts user-data to an Ignition config.
+// It returns the parts that have no Ignition equivalent, such as packages and runcmd.
+// Sudo is converted to files in /etc/sudoers.d, and timezone to an /etc/localtime link.
+func Convert(u *cloudconfig.UserData) (*Config, []Unsupported, error) {
+	t := &converter{
+		config: &Config{Ignition: Ignition{Version: Version}},
+		files:  make(map[string]*File),
+	}
+	if len(u.Boothooks) > 0 {
+		t.unsupportedf("boothooks", "scripts are not supported.  Use a systemd unit")
+	}
+	if len(u.Scripts) > 0 {
+		t.unsupportedf("scripts", "scripts are not supported.  Use a systemd unit")
+	}
+	if u.Config != nil {
+		err := t.convert(u.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return t.config, t.unsupported, nil
+}
+
+func (t *converter) passwd() *Passwd {
+	if t.config.Passwd == nil {
+		t.config.Passwd = &Passwd{}
+	}
+	return t.config.Passwd
+}
+
+func (t *converter) storage() *Storage {
+	if t.config.Storage == nil {
+		t.config.Storage = &Storage{}
+	}
+	return t.config.Storage
+}
+
+func (t *converter) convert(c *cloudconfig.Config) error {
+	if len(c.Packages) > 0 {
+		t.unsupportedf("packages", "%s.  Use rpm-ostree layering or a container", strings.Join(c.Packages, " "))
+	}
+	for i, command := range c.Runcmd {
+		t.unsupportedf(fmt.Sprintf("runcmd[%d]", i), "%v.  Use a systemd unit", command)
+	}
+	err := t.convertGroups(c.Extra["groups"])
+	if err != nil {
+		return err
+	}
+	for _, u := range c.Users {
+		err := t.convertUser(u)
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range c.Files {
+		err := t.convertFile(f)
+		if err != nil {
+			return err
+		}
+	}
+	if c.Timezone != "" {
+		overwrite := true
+		t.storage().Links = append(t.storage().Links, &Link{
+			Path:      "/etc/localtime",
+			Target:    "../usr/share/zoneinfo/" + c.Timezone,
+			Overwrite: &overwrite,
+		})
+	}
+	for _, key := range sortedKeys(c.Extra) {
+		if key != "groups" {
+			t.unsupportedf(key, "not supported")
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// convertGroups converts the cloud-init groups module, which is a list of group names,
+// or a list of maps from group names to members.
+// It is not supported by Config, so it is in Config.Extra.
+func (t *converter) convertGroups(groups any) error {
+	if groups == nil {
+		return nil
+	}
+	list, isList := groups.([]any)
+	if !isList {
+		return fmt.Errorf("groups: invalid value: %v", groups)
+	}
+	for _, item := range list {
+		switch v := item.(type) {
+		case string:
+			t.passwd().Groups = append(t.passwd().Groups, &Group{Name: v})
+		case map[any]any, map[string]any:
+			m := cloudconfig.NormalizeYaml(v).(map[string]any)
+			for _, name := range sortedKeys(m) {
+				t.passwd().Groups = append(t.passwd().Groups, &Group{Name: name})
+				if m[name] != nil {
+					t.unsupportedf("groups."+name, "members are not supported.  Use the users groups")
+				}
+			}
+		default:
+			return fmt.Errorf("groups: invalid group: %v", item)
+		}
+	}
+	return nil
+}
+
+func (t *converter) convertUser(u *cloudconfig.User) error {
+	user := &User{
+		Name:              u.Name,
+		Gecos:             u.Gecos,
+		HomeDir:           u.Homedir,
+		NoCreateHome:      u.NoCreateHome,
+		PrimaryGroup:      u.PrimaryGroup,
+		Shell:             u.Shell,
+		SSHAuthorizedKeys: u.SshAuthorizedKeys,
+	}
+	if u.Uid != "" {
+		uid, err := strconv.Atoi(u.Uid)
+		if err != nil {
+			return fmt.Errorf("user %s: invalid uid: %s", u.Name, u.Uid)
+		}
+		user.UID = &uid
+	}
+	for _, group := range strings.Split(u.Groups, ",") {
+		group = strings.TrimSpace(group)
+		if group != "" {
+			user.Groups = append(user.Groups, group)
+		}
+	}
+	t.passwd().Users = append(t.passwd().Users, user)
+	if u.Sudo != nil && u.Sudo != false {
+		values, err := cloudconfig.ToStrings(u.Sudo)
+		if err != nil {
+			return fmt.Errorf("invalid sudo value for user %s: %w", u.Name, err)
+		}
+		if len(values) == 0 {
+			values = []string{cloudconfig.DefaultSudoRule}
+		}
+		var lines []string
+		for _, value := range values {
+			lines = append(lines, u.Name+" "+value+"\n")
+		}
+		err = t.convertFile(&cloudconfig.File{
+			Path:        path.Join(cloudconfig.SudoersDir, u.Name),
+			Permissions: "0440",
+			Content:     strings.Join(lines, ""),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(u.Extra) {
+		t.unsupportedf("users."+u.Name+"."+key, "not supported")
+	}
+	return nil
+}
+
+// convertFile adds a file to storage.files.
+// Ignition does not allow duplicate paths, so entries with the same path are combined.
+func (t *converter) convertFile(f *cloudconfig.File) error {
+	mode := 0644
+	if f.Permissions != "" {
+		m, err := strconv.ParseInt(f.Permissions, 8, 32)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+		mode = int(m)
+	}
+	file, exists := t.files[f.Path]
+	if !exists {
+		file = &File{Path: f.Path, Mode: &mode}
+		t.files[f.Path] = file
+		t.storage().Files = append(t.storage().Files, file)
+	}
+	if f.Owner != "" {
+		user, group, _ := strings.Cut(f.Owner, ":")
+		file.User = &NodeUser{Name: user}
+		if group != "" {
+			file.Group = &NodeGroup{Name: group}
+		}
+	}
+	resource := Resource{Source: DataURL(f.Content)}
+	if f.Append {
+		file.Append = append(file.Append, resource)
+	} else {
+		overwrite := true
+		file.Overwrite = &overwrite
+		file.Contents = &resource
+		file.Append = nil
+		file.Mode = &mode
+	}
+	for _, key := range sortedKeys(f.Extra) {
+		t.unsupportedf("write_files."+f.Path+"."+key, "not supported")
+	}
+	return nil
+}
+
+// Marshal encodes an Ignition config as indented JSON.
+func Marshal(config *Config) ([]byte, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
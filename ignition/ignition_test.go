@@ -0,0 +1,83 @@
+package ignition
+
+import (
+	"testing"
+
+	"melato.org/cloudconfig"
+)
+
+func TestConvert(t *testing.T) {
+	config, err := cloudconfig.Unmarshal([]byte(`#cloud-config
+packages: [curl]
+groups: [docker]
+write_files:
+- path: /etc/a
+  owner: core:core
+  permissions: '0600'
+  content: a b
+- path: /etc/a
+  append: true
+  content: c
+users:
+- name: core
+  uid: "1001"
+  groups: docker, wheel
+  sudo: true
+  ssh_authorized_keys: [ssh-ed25519 AAAA]
+timezone: Europe/London
+runcmd:
+- echo a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, unsupported, err := Convert(&cloudconfig.UserData{Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unsupported) != 2 || unsupported[0].Key != "packages" || unsupported[1].Key != "runcmd[0]" {
+		t.Fatalf("%v", unsupported)
+	}
+	if len(c.Passwd.Groups) != 1 || c.Passwd.Groups[0].Name != "docker" {
+		t.Fatalf("%v", c.Passwd.Groups)
+	}
+	u := c.Passwd.Users[0]
+	if *u.UID != 1001 || len(u.Groups) != 2 || u.Groups[1] != "wheel" || len(u.SSHAuthorizedKeys) != 1 {
+		t.Fatalf("%v", u)
+	}
+	files := c.Storage.Files
+	if len(files) != 2 || files[0].Path != "/etc/sudoers.d/core" || files[1].Path != "/etc/a" {
+		t.Fatalf("%v", files)
+	}
+	f := files[1]
+	if *f.Mode != 0600 || f.User.Name != "core" || f.Contents.Source != "data:,a%20b" ||
+		len(f.Append) != 1 || f.Append[0].Source != "data:,c" {
+		t.Fatalf("%v", f)
+	}
+	if c.Storage.Links[0].Target != "../usr/share/zoneinfo/Europe/London" {
+		t.Fatalf("%v", c.Storage.Links)
+	}
+	if _, err := Marshal(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertSudo(t *testing.T) {
+	config := &cloudconfig.Config{Users: []*cloudconfig.User{
+		{Name: "a", Sudo: true},
+		{Name: "b", Sudo: []any{"ALL=(ALL) ALL"}},
+	}}
+	c, _, err := Convert(&cloudconfig.UserData{Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := c.Storage.Files
+	if len(files) != 2 || files[0].Path != "/etc/sudoers.d/a" || files[1].Path != "/etc/sudoers.d/b" {
+		t.Fatalf("%v", files)
+	}
+	config.Users = []*cloudconfig.User{{Name: "c", Sudo: []any{1}}}
+	_, _, err = Convert(&cloudconfig.UserData{Config: config})
+	if err == nil {
+		t.Fatalf("expected error for a non-string sudo value")
+	}
+}
@@ -0,0 +1,97 @@
+package cloudconfig
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxIncludeDepth limits nested #include directives.
+const MaxIncludeDepth = 10
+
+// Fetcher fetches the content of #include URLs.
+type Fetcher interface {
+	Fetch(url string) ([]byte, error)
+}
+
+// DefaultFetchTimeout is the timeout of HTTPFetcher, if it has no Client.
+const DefaultFetchTimeout = 30 * time.Second
+
+var defaultFetchClient = &http.Client{Timeout: DefaultFetchTimeout}
+
+// HTTPFetcher fetches URLs with an http.Client
+type HTTPFetcher struct {
+	// Client is the http client.  If nil, a client with DefaultFetchTimeout is used.
+	Client *http.Client
+}
+
+func (t *HTTPFetcher) Fetch(url string) ([]byte, error) {
+	client := t.Client
+	if client == nil {
+		client = defaultFetchClient
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// includeURLs returns the URLs of an #include or #include-once document.
+// The first line, blank lines and comments are ignored.
+func includeURLs(data []byte) []string {
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if first && strings.HasPrefix(line, "#include") {
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls
+}
+
+// include fetches the URLs of an #include document and parses their content.
+// If once is true, each URL is fetched at most once by the same UserDataReader.
+func (t *userDataParser) include(data []byte, once bool) error {
+	if t.depth >= MaxIncludeDepth {
+		return fmt.Errorf("too many nested includes")
+	}
+	if t.reader.Fetcher == nil {
+		return fmt.Errorf("#include is not supported without a fetcher")
+	}
+	for _, url := range includeURLs(data) {
+		if once {
+			if t.reader.included[url] {
+				continue
+			}
+			if t.reader.included == nil {
+				t.reader.included = make(map[string]bool)
+			}
+			t.reader.included[url] = true
+		}
+		content, err := t.reader.Fetcher.Fetch(url)
+		if err != nil {
+			return err
+		}
+		t.depth++
+		err = t.handlePart(TypePlain, url, "", content)
+		t.depth--
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package cloudconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// LintRule describes a lint rule.
+type LintRule struct {
+	ID          string
+	Severity    Severity
+	Description string
+	check       func(t *linter)
+}
+
+// LintIgnoreComment suppresses lint rules for a key or value,
+// when followed by a comma-separated list of rule IDs in a comment:
+//
+//	sudo: "ALL=(ALL) ALL" # lint:ignore sudo-string
+const LintIgnoreComment = "lint:ignore"
+
+// LintRules returns the lint rules.
+func LintRules() []*LintRule {
+	return []*LintRule{
+		{"sudo-string", SeverityWarning,
+			"sudo strings are specific to sudo or doas, and fail if both /etc/sudoers.d and /etc/doas.d exist",
+			lintSudoString},
+		{"owner-before-user", SeverityError,
+			"write_files owned by a user or group created in the same config must be deferred",
+			lintOwnerBeforeUser},
+		{"duplicate-package", SeverityWarning,
+			"packages should be listed once",
+			lintDuplicatePackage},
+		{"runcmd-missing-file", SeverityWarning,
+			"runcmd runs a file that is not a system program and is not written by write_files",
+			lintRuncmdMissingFile},
+		{"invalid-ssh-key", SeverityError,
+			"ssh_authorized_keys must be valid public keys",
+			lintSSHKey},
+	}
+}
+
+// LintOptions specifies which lint rules to run.
+type LintOptions struct {
+	// Disable lists the IDs of rules that are not run.
+	Disable []string
+}
+
+type linter struct {
+	config      *Config
+	root        *yaml3.Node
+	rule        *LintRule
+	diagnostics []Diagnostic
+}
+
+// Lint checks a cloud-config document for semantic problems.
+// Diagnostics have line and column positions, if data is YAML or JSON.
+func Lint(data []byte, options *LintOptions) ([]Diagnostic, error) {
+	config, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml3.Node
+	var root *yaml3.Node
+	if yaml3.Unmarshal(data, &doc) == nil && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	return lint(config, root, options), nil
+}
+
+// LintConfig checks a config for semantic problems.  The diagnostics have no positions.
+func LintConfig(config *Config, options *LintOptions) []Diagnostic {
+	return lint(config, nil, options)
+}
+
+func lint(config *Config, root *yaml3.Node, options *LintOptions) []Diagnostic {
+	disabled := make(map[string]bool)
+	if options != nil {
+		for _, id := range options.Disable {
+			disabled[id] = true
+		}
+	}
+	t := &linter{config: config, root: root}
+	for _, rule := range LintRules() {
+		if !disabled[rule.ID] {
+			t.rule = rule
+			rule.check(t)
+		}
+	}
+	return t.diagnostics
+}
+
+func formatPath(path []any) string {
+	var buf bytes.Buffer
+	for _, p := range path {
+		switch v := p.(type) {
+		case int:
+			fmt.Fprintf(&buf, "[%d]", v)
+		default:
+			if buf.Len() > 0 {
+				buf.WriteString(".")
+			}
+			fmt.Fprintf(&buf, "%v", v)
+		}
+	}
+	return buf.String()
+}
+
+// findNode returns the key and value nodes at path, if they exist.
+// path elements are mapping keys (string) or sequence indexes (int).
+func findNode(node *yaml3.Node, path []any) (key *yaml3.Node, value *yaml3.Node) {
+	value = node
+	for _, p := range path {
+		if value == nil {
+			return nil, nil
+		}
+		switch v := p.(type) {
+		case string:
+			var next *yaml3.Node
+			if value.Kind == yaml3.MappingNode {
+				for i := 0; i+1 < len(value.Content); i += 2 {
+					if value.Content[i].Value == v {
+						key, next = value.Content[i], value.Content[i+1]
+					}
+				}
+			}
+			value = next
+		case int:
+			key = nil
+			if value.Kind == yaml3.SequenceNode && v < len(value.Content) {
+				value = value.Content[v]
+			} else {
+				value = nil
+			}
+		}
+	}
+	return key, value
+}
+
+func isIgnored(node *yaml3.Node, rule string) bool {
+	if node == nil {
+		return false
+	}
+	for _, comment := range []string{node.HeadComment, node.LineComment} {
+		_, rules, found := strings.Cut(comment, LintIgnoreComment)
+		if !found {
+			continue
+		}
+		for _, id := range strings.Split(strings.TrimSpace(rules), ",") {
+			if strings.TrimSpace(id) == rule {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *linter) addf(path []any, format string, args ...any) {
+	d := Diagnostic{Severity: t.rule.Severity, Rule: t.rule.ID,
+		Message: formatPath(path) + ": " + fmt.Sprintf(format, args...)}
+	if t.root != nil {
+		key, value := findNode(t.root, path)
+		if isIgnored(key, t.rule.ID) || isIgnored(value, t.rule.ID) {
+			return
+		}
+		node := key
+		if node == nil {
+			node = value
+		}
+		if node != nil {
+			d.Line = node.Line
+			d.Column = node.Column
+		}
+	}
+	t.diagnostics = append(t.diagnostics, d)
+}
+
+func lintSudoString(t *linter) {
+	for i, u := range t.config.Users {
+		switch u.Sudo.(type) {
+		case nil, bool:
+		default:
+			t.addf([]any{"users", i, "sudo"}, "sudo string will not work if both %s and %s exist", SudoersDir, DoasDir)
+		}
+	}
+}
+
+func lintOwnerBeforeUser(t *linter) {
+	created := make(map[string]bool)
+	for _, u := range t.config.Users {
+		created[u.Name] = true
+		if u.PrimaryGroup != "" {
+			created[u.PrimaryGroup] = true
+		}
+	}
+	for i, f := range t.config.Files {
+		if f.Defer || f.Owner == "" {
+			continue
+		}
+		user, group, _ := strings.Cut(f.Owner, ":")
+		for _, name := range []string{user, group} {
+			if created[name] {
+				t.addf([]any{"write_files", i, "owner"}, "%s is created in users, after the file is written.  Use defer: true", name)
+				break
+			}
+		}
+	}
+}
+
+func lintDuplicatePackage(t *linter) {
+	seen := make(map[string]bool)
+	for i, pkg := range t.config.Packages {
+		if seen[pkg] {
+			t.addf([]any{"packages", i}, "duplicate package: %s", pkg)
+		}
+		seen[pkg] = true
+	}
+}
+
+// systemDirs contains programs that are not expected to be written by write_files
+var systemDirs = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin"}
+
+// commandFile returns the file that a command runs, if it is an absolute path.
+// For shell invocations, such as "sh /x.sh" or ". /x.sh", it returns the script file.
+func commandFile(command any) string {
+	var args []string
+	if c, isMap, err := CommandMap(command); isMap {
+		if err != nil {
+			return ""
+		}
+		command = c.Args
+		if c.Script != "" {
+			command = c.Script
+		}
+	}
+	script, isScript := CommandScript(command)
+	if isScript {
+		args = strings.Fields(script)
+	} else {
+		args, _ = CommandArgs(command)
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	file := args[0]
+	switch filepath.Base(file) {
+	case "sh", "bash", ".", "source":
+		if len(args) > 1 {
+			file = args[1]
+		}
+	}
+	if !filepath.IsAbs(file) {
+		return ""
+	}
+	return file
+}
+
+func lintRuncmdMissingFile(t *linter) {
+	written := make(map[string]bool)
+	for _, f := range t.config.Files {
+		written[filepath.Clean(f.Path)] = true
+	}
+	for i, command := range t.config.Runcmd {
+		file := commandFile(command)
+		if file == "" || written[filepath.Clean(file)] {
+			continue
+		}
+		isSystem := false
+		for _, dir := range systemDirs {
+			if filepath.Dir(file) == dir {
+				isSystem = true
+			}
+		}
+		if !isSystem {
+			t.addf([]any{"runcmd", i}, "%s is not written by write_files", file)
+		}
+	}
+}
+
+// ParseAuthorizedKey checks that a line has the authorized_keys format:
+// [options] keytype base64-key [comment]
+func ParseAuthorizedKey(line string) error {
+	fields := strings.Fields(line)
+	for len(fields) > 0 && !isKeyType(fields[0]) {
+		// skip options
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("missing key type or key")
+	}
+	keyType := fields[0]
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid base64 key: %w", err)
+	}
+	if len(blob) < 4 {
+		return fmt.Errorf("key too short")
+	}
+	n := binary.BigEndian.Uint32(blob)
+	if uint64(n) > uint64(len(blob)-4) || string(blob[4:4+n]) != keyType {
+		return fmt.Errorf("key does not match key type %s", keyType)
+	}
+	return nil
+}
+
+func isKeyType(s string) bool {
+	switch s {
+	case "ssh-rsa", "ssh-dss", "ssh-ed25519", "sk-ssh-ed25519@openssh.com",
+		"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+		"sk-ecdsa-sha2-nistp256@openssh.com":
+		return true
+	}
+	return false
+}
+
+func lintSSHKey(t *linter) {
+	for i, u := range t.config.Users {
+		for j, key := range u.SshAuthorizedKeys {
+			err := ParseAuthorizedKey(key)
+			if err != nil {
+				t.addf([]any{"users", i, "ssh_authorized_keys", j}, "%v", err)
+			}
+		}
+	}
+}
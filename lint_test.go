@@ -0,0 +1,65 @@
+package cloudconfig
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func testSSHKey() string {
+	keyType := "ssh-ed25519"
+	blob := binary.BigEndian.AppendUint32(nil, uint32(len(keyType)))
+	blob = append(blob, keyType...)
+	blob = binary.BigEndian.AppendUint32(blob, 32)
+	blob = append(blob, make([]byte, 32)...)
+	return keyType + " " + base64.StdEncoding.EncodeToString(blob) + " a@b"
+}
+
+func TestLint(t *testing.T) {
+	data := []byte(`#cloud-config
+packages: [a, b, a]
+write_files:
+- path: /usr/local/bin/setup.sh
+  owner: deploy:deploy
+  content: a
+users:
+- name: deploy
+  sudo: "ALL=(ALL) ALL"
+  ssh_authorized_keys:
+  - ` + testSSHKey() + `
+  - ssh-rsa A foo@bar
+- name: b
+  sudo: "ALL=(ALL) ALL" # lint:ignore sudo-string
+runcmd:
+- /usr/local/bin/setup.sh
+- [sh, /opt/missing.sh]
+- /bin/true
+`)
+	diagnostics, err := Lint(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		":9:3: warning: users[0].sudo: sudo string",
+		":5:3: error: write_files[0].owner: deploy",
+		":2:18: warning: packages[2]: duplicate package: a",
+		":17:3: warning: runcmd[1]: /opt/missing.sh",
+		":12:5: error: users[0].ssh_authorized_keys[1]:",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("%v", diagnostics)
+	}
+	for i, d := range diagnostics {
+		if !strings.HasPrefix(d.String(), expected[i]) {
+			t.Errorf("%s, expected %s", d.String(), expected[i])
+		}
+	}
+	diagnostics, err = Lint(data, &LintOptions{Disable: []string{"sudo-string", "duplicate-package"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 3 {
+		t.Fatalf("%v", diagnostics)
+	}
+}
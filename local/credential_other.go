@@ -0,0 +1,12 @@
+//go:build !unix
+
+package local
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func setUser(cmd *exec.Cmd, username string) error {
+	return fmt.Errorf("cannot run commands as another user on this system")
+}
@@ -0,0 +1,53 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"melato.org/cloudconfig/execprefix"
+)
+
+// Escalation tools, for EscalationPrefix
+const (
+	EscalateAuto = "auto"
+	EscalateSudo = "sudo"
+	EscalateDoas = "doas"
+)
+
+// EscalationPrefix returns the command prefix that runs privileged commands with a tool:
+//   - "": no prefix
+//   - sudo: sudo -n
+//   - doas: doas -n
+//   - auto: no prefix if running as root, otherwise sudo -n or doas -n, whichever is installed.
+//
+// -n makes the tool fail, instead of prompting for a password.
+func EscalationPrefix(tool string) ([]string, error) {
+	switch tool {
+	case "":
+		return nil, nil
+	case EscalateSudo, EscalateDoas:
+		return []string{tool, "-n"}, nil
+	case EscalateAuto:
+		if os.Geteuid() == 0 {
+			return nil, nil
+		}
+		for _, tool := range []string{EscalateSudo, EscalateDoas} {
+			if _, err := exec.LookPath(tool); err == nil {
+				return []string{tool, "-n"}, nil
+			}
+		}
+		return nil, fmt.Errorf("not running as root, and neither sudo nor doas is installed")
+	default:
+		return nil, fmt.Errorf("unrecognized escalation tool: %s.  accepted values are auto, sudo, doas", tool)
+	}
+}
+
+// escalated returns a BaseConfigurer that runs commands and writes files through the Escalate prefix,
+// or nil if there is no Escalate prefix.
+func (t *BaseConfigurer) escalated() *execprefix.BaseConfigurer {
+	if len(t.Escalate) == 0 {
+		return nil
+	}
+	return &execprefix.BaseConfigurer{Prefix: t.Escalate, Log: t.Log}
+}
@@ -1,19 +1,25 @@
 package local
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"os/exec"
 	"os/user"
-	"path/filepath"
 	"strconv"
 	"strings"
+
+	"melato.org/cloudconfig"
 )
 
 type BaseConfigurer struct {
 	Log io.Writer
+	// Escalate is a command prefix, such as sudo -n, for running as an unprivileged user.
+	// If set, commands, scripts, and file writes are run through it.
+	// See EscalationPrefix.
+	Escalate []string
 }
 
 func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
@@ -21,20 +27,76 @@ func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
 }
 
 func (t *BaseConfigurer) RunCommand(args ...string) error {
+	return t.RunCommandContext(context.Background(), args...)
+}
+
+// RunCommandContext runs a command, and kills it when ctx is done.
+func (t *BaseConfigurer) RunCommandContext(ctx context.Context, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("command has 0 args")
 	}
-	fmt.Printf("log\n: %x\n", t.Log)
-	cmd := exec.Command(args[0], args[1:]...)
-	fmt.Printf("%s\n", cmd.String())
+	if e := t.escalated(); e != nil {
+		return e.RunCommandContext(ctx, args...)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = t.Log
 	cmd.Stderr = t.Log
 	return cmd.Run()
 }
 
+// RunCommandOptions runs a command with options.
+// With Escalate, it runs the command as options.User with sudo -u or doas -u.
+func (t *BaseConfigurer) RunCommandOptions(ctx context.Context, options *cloudconfig.CommandOptions, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	var cmd *exec.Cmd
+	if len(t.Escalate) > 0 {
+		all := append([]string(nil), t.Escalate...)
+		if options.User != "" {
+			all = append(all, "-u", options.User)
+		}
+		if len(options.Env) > 0 {
+			// sudo resets the environment
+			all = append(append(all, "env"), options.Env...)
+		}
+		all = append(all, args...)
+		cmd = exec.CommandContext(ctx, all[0], all[1:]...)
+	} else {
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = os.Environ()
+		if options.User != "" {
+			err := setUser(cmd, options.User)
+			if err != nil {
+				return err
+			}
+		}
+		cmd.Env = append(cmd.Env, options.Env...)
+	}
+	cmd.Dir = options.Dir
+	if options.Stdin != "" {
+		cmd.Stdin = strings.NewReader(options.Stdin)
+	}
+	if options.Output != nil {
+		cmd.Stdout = options.Output
+	} else {
+		cmd.Stdout = t.Log
+	}
+	cmd.Stderr = t.Log
+	return cmd.Run()
+}
+
 func (t *BaseConfigurer) RunScript(script string) error {
-	cmd := exec.Command("/bin/sh")
+	return t.RunScriptContext(context.Background(), script)
+}
+
+// RunScriptContext runs a script, and kills sh when ctx is done.
+func (t *BaseConfigurer) RunScriptContext(ctx context.Context, script string) error {
+	if e := t.escalated(); e != nil {
+		return e.RunScriptContext(ctx, script)
+	}
+	cmd := exec.CommandContext(ctx, "/bin/sh")
 	cmd.Stdin = strings.NewReader(script)
 	cmd.Stdout = t.Log
 	cmd.Stderr = t.Log
@@ -77,29 +139,16 @@ func (t *BaseConfigurer) findUidGid(owner string) (uid int, gid int, err error)
 	return parse(u.Uid, g.Gid)
 }
 
-func (t *BaseConfigurer) ensureDirExists(path string) error {
-	dir := filepath.Dir(path)
-	if dir != "." && dir != "/" {
-		err := os.MkdirAll(dir, fs.FileMode(0775))
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (t *BaseConfigurer) WriteFile(path string, data []byte, perm fs.FileMode) error {
-	err := t.ensureDirExists(path)
-	if err != nil {
-		return err
+	if e := t.escalated(); e != nil {
+		return e.WriteFile(path, data, perm)
 	}
 	return os.WriteFile(path, data, perm)
 }
 
 func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode) error {
-	err := t.ensureDirExists(path)
-	if err != nil {
-		return err
+	if e := t.escalated(); e != nil {
+		return e.AppendFile(path, data, perm)
 	}
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, perm)
 	if err != nil {
@@ -113,9 +162,14 @@ func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode)
 	return f.Close()
 }
 
+// FileExists checks if a file exists.
+// With Escalate, it runs test -e with the prefix, so that it can check files that the user cannot access.
 func (t *BaseConfigurer) FileExists(path string) (bool, error) {
+	if e := t.escalated(); e != nil {
+		return e.FileExists(path)
+	}
 	_, err := os.Stat(path)
-	if err != nil {
+	if err == nil {
 		return true, nil
 	}
 	if os.IsNotExist(err) {
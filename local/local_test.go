@@ -1,14 +1,138 @@
 package local
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/cloudconfigtest"
 )
 
 func TestInterfaces(t *testing.T) {
 	local := &BaseConfigurer{}
 	var _ cloudconfig.BaseConfigurer = local
 	var _ cloudconfig.BaseUserHomeDir = local
+	var _ cloudconfig.BaseContext = local
+	var _ cloudconfig.BaseCommandOptions = local
 	var _ cloudconfig.BaseApplySudo = local
 }
+
+func TestEscalationPrefix(t *testing.T) {
+	prefix, err := EscalationPrefix(EscalateDoas)
+	if err != nil || strings.Join(prefix, " ") != "doas -n" {
+		t.Fatalf("%v %v", prefix, err)
+	}
+	prefix, err = EscalationPrefix("")
+	if err != nil || prefix != nil {
+		t.Fatalf("%v %v", prefix, err)
+	}
+	_, err = EscalationPrefix("su")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	var log strings.Builder
+	base := &BaseConfigurer{Escalate: []string{"env", "CLOUDCONFIG_TEST=a"}, Log: &log}
+	file := filepath.Join(t.TempDir(), "a")
+	err := base.WriteFile(file, []byte("a"), 0600)
+	if err == nil {
+		err = base.AppendFile(file, []byte("b"), 0600)
+	}
+	if err == nil {
+		err = base.RunScript("echo $CLOUDCONFIG_TEST")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "ab" {
+		t.Fatalf("%v %q", err, string(data))
+	}
+	if log.String() != "a\n" {
+		t.Fatalf("%q", log.String())
+	}
+}
+
+func TestEscalateFileExists(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	prefix := []string{"sh", "-c", `echo "$@" >> "$0"; exec "$@"`, log}
+	base := &BaseConfigurer{Escalate: prefix}
+	exists, err := base.FileExists(log + ".missing")
+	if err != nil || exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+	exists, err = base.FileExists(log)
+	if err != nil || !exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+	data, _ := os.ReadFile(log)
+	if !strings.HasPrefix(string(data), "test -e ") {
+		t.Fatalf("%q", string(data))
+	}
+}
+
+func TestEscalateFileExistsDenied(t *testing.T) {
+	// sudo -n exits with 1 when it needs a password, as test -e does for a missing file.
+	base := &BaseConfigurer{Escalate: []string{"sh", "-c", "exit 1"}}
+	_, err := base.FileExists(t.TempDir())
+	if err == nil {
+		t.Fatalf("should fail when the escalation prefix fails")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	cloudconfigtest.RunConformance(t, func(t *testing.T) (cloudconfig.BaseConfigurer, string) {
+		return &BaseConfigurer{}, t.TempDir()
+	})
+}
+
+func TestEscalateConformance(t *testing.T) {
+	cloudconfigtest.RunConformance(t, func(t *testing.T) (cloudconfig.BaseConfigurer, string) {
+		return &BaseConfigurer{Escalate: []string{"env"}}, t.TempDir()
+	})
+}
+
+func TestCommandTimeout(t *testing.T) {
+	configurer := cloudconfig.NewConfigurer(&BaseConfigurer{})
+	configurer.CommandTimeout = 100 * time.Millisecond
+	start := time.Now()
+	err := configurer.Apply(&cloudconfig.Config{Runcmd: cloudconfig.Commands{
+		[]string{"sleep", "5"},
+	}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("%v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatalf("command was not stopped")
+	}
+	err = configurer.Apply(&cloudconfig.Config{Runcmd: cloudconfig.Commands{"sleep 0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunCommandOptions(t *testing.T) {
+	dir := t.TempDir()
+	var output strings.Builder
+	base := &BaseConfigurer{}
+	err := base.RunCommandOptions(context.Background(), &cloudconfig.CommandOptions{
+		Env:    []string{"CLOUDCONFIG_TEST=a"},
+		Dir:    dir,
+		Stdin:  "b",
+		Output: &output,
+	}, "sh", "-c", `echo $CLOUDCONFIG_TEST $(cat) $(pwd)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "a b "+dir+"\n" {
+		t.Fatalf("%q", output.String())
+	}
+}
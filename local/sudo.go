@@ -29,7 +29,7 @@ func (t *BaseConfigurer) applySudo(user string, values []string) error {
 		fmt.Fprintf(&buf, "%s %s\n", user, value)
 	}
 	file := filepath.Join(SudoersDir, user)
-	return os.WriteFile(file, buf.Bytes(), os.FileMode(0400))
+	return t.WriteFile(file, buf.Bytes(), os.FileMode(0400))
 }
 
 func (t *BaseConfigurer) applyDoas(user string, values []string) error {
@@ -41,7 +41,7 @@ func (t *BaseConfigurer) applyDoas(user string, values []string) error {
 		fmt.Fprintf(&buf, "%s %s\n", value, user)
 	}
 	file := filepath.Join(DoasDir, user)
-	return os.WriteFile(file, buf.Bytes(), os.FileMode(0400))
+	return t.WriteFile(file, buf.Bytes(), os.FileMode(0400))
 }
 
 func (t *BaseConfigurer) ApplySudo(username string, values []string) error {
@@ -18,9 +18,25 @@ func main() {
 	cmd := &command.SimpleCommand{}
 	var app cli.App
 	cmd.Command("apply").Flags(&app).RunFunc(app.Apply)
-	cmd.Command("print").RunFunc(cli.Print)
+	var osCmd cli.OSCmd
+	cmd.Command("plan").Flags(&osCmd).RunFunc(osCmd.Plan)
+	cmd.Command("export-sh").Flags(&osCmd).RunFunc(osCmd.ExportSh)
+	var dockerfileCmd cli.DockerfileCmd
+	cmd.Command("export-dockerfile").Flags(&dockerfileCmd).RunFunc(dockerfileCmd.Export)
+	var extractCmd cli.ExtractCmd
+	cmd.Command("extract").Flags(&extractCmd).RunFunc(extractCmd.Extract)
+	var ignitionCmd cli.IgnitionCmd
+	cmd.Command("export-ignition").Flags(&ignitionCmd).RunFunc(ignitionCmd.Convert)
+	var printCmd cli.PrintCmd
+	cmd.Command("print").Flags(&printCmd).RunFunc(printCmd.Print)
+	cmd.Command("merge").RunFunc(cli.Merge)
 	cmd.Command("packages").RunFunc(cli.Packages)
-	cmd.Command("parse").RunFunc(cli.Parse)
+	var parseCmd cli.ParseCmd
+	cmd.Command("parse").Flags(&parseCmd).RunFunc(parseCmd.Parse)
+	cmd.Command("schema").RunFunc(cli.Schema)
+	var lintCmd cli.LintCmd
+	cmd.Command("lint").Flags(&lintCmd).RunFunc(lintCmd.Lint)
+	cmd.Command("lint-rules").RunFunc(cli.LintRules)
 	cmd.Command("version").RunFunc(func() { fmt.Println(version) })
 
 	usage.Apply(cmd, usageData)
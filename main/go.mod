@@ -1,6 +1,6 @@
 module main
 
-go 1.19
+go 1.20
 
 replace melato.org/cloudconfig => ../
 
@@ -9,4 +9,9 @@ require (
 	melato.org/command v1.0.0
 )
 
-require gopkg.in/yaml.v2 v2.4.0 // indirect
+require (
+	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
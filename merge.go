@@ -0,0 +1,277 @@
+package cloudconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultMergeHow is the merge specification that cloud-init uses
+// for configs that do not specify merge_how or merge_type.
+const DefaultMergeHow = "dict(replace)+list()+str()"
+
+// Mergers maps a merger name (dict, list, str) to its settings.
+// It is parsed from merge_how or merge_type.
+type Mergers map[string][]string
+
+func (t Mergers) has(name, setting string) bool {
+	for _, s := range t[name] {
+		if s == setting {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMergeHow parses a merge_how value.
+// It may be a string, such as "list(append)+dict(no_replace,recurse_list)+str()",
+// or a list of {name, settings} items.
+func ParseMergeHow(v any) (Mergers, error) {
+	switch how := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return parseMergeString(how)
+	case []any:
+		mergers := make(Mergers)
+		for _, item := range how {
+			m, isMap := NormalizeYaml(item).(map[string]any)
+			if !isMap {
+				return nil, fmt.Errorf("invalid merger: %v", item)
+			}
+			name, isString := m["name"].(string)
+			if !isString || name == "" {
+				return nil, fmt.Errorf("missing merger name: %v", item)
+			}
+			settings, err := ToStrings(m["settings"])
+			if m["settings"] != nil && err != nil {
+				return nil, fmt.Errorf("merger %s: %w", name, err)
+			}
+			mergers[name] = settings
+		}
+		return mergers, nil
+	}
+	return nil, fmt.Errorf("invalid merge_how: %v", v)
+}
+
+func parseMergeString(s string) (Mergers, error) {
+	mergers := make(Mergers)
+	for _, part := range strings.Split(s, "+") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name := part
+		var settings []string
+		i := strings.Index(part, "(")
+		if i >= 0 {
+			if !strings.HasSuffix(part, ")") {
+				return nil, fmt.Errorf("invalid merger: %s", part)
+			}
+			name = strings.TrimSpace(part[:i])
+			for _, setting := range strings.Split(part[i+1:len(part)-1], ",") {
+				setting = strings.TrimSpace(setting)
+				if setting != "" {
+					settings = append(settings, setting)
+				}
+			}
+		}
+		mergers[name] = settings
+	}
+	return mergers, nil
+}
+
+// configMergers returns the mergers specified by a config,
+// or the default mergers, if the config does not specify any.
+func configMergers(config *Config) (Mergers, error) {
+	how := config.MergeHow
+	if how == nil {
+		how = config.MergeType
+	}
+	if how == nil {
+		how = DefaultMergeHow
+	}
+	return ParseMergeHow(how)
+}
+
+// Merge combines configs into a single config, using cloud-init merge semantics.
+// Each config is merged into the result of merging the previous configs,
+// using its own merge_how (or merge_type), or DefaultMergeHow.
+// The merged config does not have merge_how or merge_type.
+func Merge(configs ...*Config) (*Config, error) {
+	var merged any = map[string]any{}
+	for i, config := range configs {
+		mergers, err := configMergers(config)
+		if err != nil {
+			return nil, fmt.Errorf("config %d: %w", i+1, err)
+		}
+		m, err := configToMap(config)
+		if err != nil {
+			return nil, err
+		}
+		delete(m, "merge_how")
+		delete(m, "merge_type")
+		merged = mergers.merge(merged, m)
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	var result Config
+	err = yaml.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func configToMap(config *Config) (map[string]any, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	err = yaml.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = make(map[string]any)
+	}
+	return NormalizeYaml(m).(map[string]any), nil
+}
+
+// NormalizeYaml returns a copy of v, converting the map[any]any values produced by yaml.v2 to map[string]any,
+// so that they can be merged or encoded as JSON.
+func NormalizeYaml(v any) any {
+	switch x := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(x))
+		for k, value := range x {
+			m[fmt.Sprintf("%v", k)] = NormalizeYaml(value)
+		}
+		return m
+	case map[string]any:
+		m := make(map[string]any, len(x))
+		for k, value := range x {
+			m[k] = NormalizeYaml(value)
+		}
+		return m
+	case []any:
+		list := make([]any, len(x))
+		for i, value := range x {
+			list[i] = NormalizeYaml(value)
+		}
+		return list
+	}
+	return v
+}
+
+func (t Mergers) merge(old, value any) any {
+	switch v := old.(type) {
+	case map[string]any:
+		if _, ok := t["dict"]; ok {
+			return t.mergeDict(v, value)
+		}
+	case []any:
+		if _, ok := t["list"]; ok {
+			return t.mergeList(v, value)
+		}
+	case string:
+		if _, ok := t["str"]; ok {
+			return t.mergeStr(v, value)
+		}
+	}
+	return value
+}
+
+func (t Mergers) mergeDict(old map[string]any, value any) any {
+	m, isMap := value.(map[string]any)
+	if !isMap {
+		return old
+	}
+	replace := t.has("dict", "replace")
+	mergeSame := func(oldValue, newValue any) any {
+		if replace {
+			return newValue
+		}
+		switch newValue.(type) {
+		case []any:
+			if t.has("dict", "recurse_array") || t.has("dict", "recurse_list") {
+				return t.merge(oldValue, newValue)
+			}
+		case string:
+			if t.has("dict", "recurse_str") {
+				return t.merge(oldValue, newValue)
+			}
+		case map[string]any:
+			return t.merge(oldValue, newValue)
+		}
+		return oldValue
+	}
+	merged := make(map[string]any, len(old)+len(m))
+	for k, v := range old {
+		merged[k] = v
+	}
+	for k, v := range m {
+		oldValue, exists := merged[k]
+		if !exists {
+			merged[k] = v
+		} else if v == nil && t.has("dict", "allow_delete") {
+			delete(merged, k)
+		} else {
+			merged[k] = mergeSame(oldValue, v)
+		}
+	}
+	return merged
+}
+
+func (t Mergers) mergeList(old []any, value any) any {
+	list, isList := value.([]any)
+	replace := !(t.has("list", "append") || t.has("list", "prepend") || t.has("list", "no_replace"))
+	if !isList {
+		if replace {
+			return value
+		}
+		return old
+	}
+	merged := make([]any, 0, len(old)+len(list))
+	switch {
+	case t.has("list", "append"):
+		return append(append(merged, old...), list...)
+	case t.has("list", "prepend"):
+		return append(append(merged, list...), old...)
+	case t.has("list", "no_replace"):
+		return append(merged, old...)
+	}
+	// replace same indexes
+	merged = append(merged, old...)
+	for i := 0; i < len(merged) && i < len(list); i++ {
+		newValue := list[i]
+		switch newValue.(type) {
+		case []any:
+			if t.has("list", "recurse_array") {
+				newValue = t.merge(merged[i], newValue)
+			}
+		case string:
+			if t.has("list", "recurse_str") {
+				newValue = t.merge(merged[i], newValue)
+			}
+		case map[string]any:
+			if t.has("list", "recurse_dict") {
+				newValue = t.merge(merged[i], newValue)
+			}
+		}
+		merged[i] = newValue
+	}
+	return merged
+}
+
+func (t Mergers) mergeStr(old string, value any) any {
+	s, isString := value.(string)
+	if isString && t.has("str", "append") {
+		return old + s
+	}
+	return value
+}
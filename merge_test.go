@@ -0,0 +1,63 @@
+package cloudconfig
+
+import (
+	"testing"
+)
+
+func TestParseMergeHow(t *testing.T) {
+	m, err := ParseMergeHow("list(append)+dict(no_replace,recurse_list)+str()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.has("list", "append") || !m.has("dict", "recurse_list") {
+		t.Fatalf("%v", m)
+	}
+	if _, ok := m["str"]; !ok {
+		t.Fatalf("missing str: %v", m)
+	}
+	m, err = ParseMergeHow([]any{
+		map[any]any{"name": "list", "settings": []any{"prepend"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.has("list", "prepend") {
+		t.Fatalf("%v", m)
+	}
+}
+
+func TestMergeDefault(t *testing.T) {
+	a := &Config{Packages: []string{"a"}, Timezone: "UTC"}
+	b := &Config{Packages: []string{"b"}}
+	c, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"b"}, c.Packages) || c.Timezone != "UTC" {
+		t.Fatalf("%v", c)
+	}
+}
+
+func TestMergeAppend(t *testing.T) {
+	a := &Config{Packages: []string{"a"}, Runcmd: Commands{"echo a"}}
+	b := &Config{Packages: []string{"b"}, Runcmd: Commands{"echo b"},
+		MergeHow: "list(append)+dict(no_replace,recurse_list)+str()"}
+	c, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"a", "b"}, c.Packages) || len(c.Runcmd) != 2 {
+		t.Fatalf("%v", c)
+	}
+	if c.MergeHow != nil {
+		t.Fatalf("merge_how should not be merged")
+	}
+	b.MergeHow = "list(prepend)+dict(no_replace,recurse_list)"
+	c, err = Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"b", "a"}, c.Packages) {
+		t.Fatalf("%v", c.Packages)
+	}
+}
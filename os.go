@@ -22,3 +22,28 @@ type OSType interface {
 
 	SetTimezoneCommand(timezone string) []string
 }
+
+// Optional OSType interface for updating the package index before installing packages.
+// It is used where the package index may be missing, such as in container images.
+type OSUpdatePackages interface {
+	// UpdatePackagesCommand returns a command that is passed as input to sh.
+	UpdatePackagesCommand() string
+}
+
+// Optional OSType interface for waiting until no other process holds the package manager lock.
+// If implemented, and Configurer.WaitPackageLock is set, Configurer runs it before each package install attempt.
+// OSInstallPackageWait is preferred, if the package manager can wait for its lock.
+type OSWaitPackageLock interface {
+	// WaitPackageLockCommand returns a command that is passed as input to sh.
+	// It should return when the lock is free, or fail after waiting for a while.
+	WaitPackageLockCommand() string
+}
+
+// Optional OSType interface for package managers that can wait for their lock,
+// such as apt-get, whose lock unattended-upgrades holds on a freshly booted Debian instance.
+// If implemented, and Configurer.WaitPackageLock is set, Configurer uses it instead of InstallPackageCommand.
+type OSInstallPackageWait interface {
+	// InstallPackageWaitCommand returns a command that installs a package, waiting for the lock if necessary.
+	// The command is passed as input to sh.
+	InstallPackageWaitCommand(pkg string) string
+}
@@ -18,6 +18,15 @@ func (t *Alpine) InstallPackageCommand(pkg string) string {
 	return "apk add " + pkg
 }
 
+func (t *Alpine) UpdatePackagesCommand() string {
+	return "apk update"
+}
+
+// WaitPackageLockCommand waits up to 5 minutes for the apk lock.
+func (t *Alpine) WaitPackageLockCommand() string {
+	return waitLockCommand("/lib/apk/db/lock")
+}
+
 func (t *Alpine) AddUserCommand(u *cloudconfig.User) []string {
 	args := []string{"adduser", "-g", u.Gecos, "-D"}
 	if u.Uid != "" {
@@ -1,6 +1,8 @@
 package ostype
 
 import (
+	"fmt"
+
 	"melato.org/cloudconfig"
 )
 
@@ -16,6 +18,16 @@ func (t *Debian) InstallPackageCommand(pkg string) string {
 	return "DEBIAN_FRONTEND=noninteractive apt-get -y install " + pkg
 }
 
+func (t *Debian) UpdatePackagesCommand() string {
+	return "DEBIAN_FRONTEND=noninteractive apt-get update"
+}
+
+// InstallPackageWaitCommand returns an install command that waits up to 5 minutes for the dpkg lock,
+// which unattended-upgrades holds after boot.
+func (t *Debian) InstallPackageWaitCommand(pkg string) string {
+	return fmt.Sprintf("DEBIAN_FRONTEND=noninteractive apt-get -o DPkg::Lock::Timeout=%d -y install %s", waitLockSeconds, pkg)
+}
+
 func (t *Debian) AddUserCommand(u *cloudconfig.User) []string {
 	args := []string{"adduser", u.Name, "--disabled-password", "--gecos", u.Gecos}
 	if u.Uid != "" {
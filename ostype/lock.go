@@ -0,0 +1,20 @@
+package ostype
+
+import (
+	"fmt"
+	"strings"
+)
+
+// waitLockSeconds is how long the package manager lock waits last.
+const waitLockSeconds = 300
+
+// waitLockCommand returns a sh command that waits while any process has one of the lock files open.
+// It fails if the files are still open after waitLockSeconds.
+// If fuser is not installed, it prints a warning and does not wait.
+func waitLockCommand(files ...string) string {
+	return fmt.Sprintf(`if ! command -v fuser >/dev/null; then echo "fuser not found: not waiting for the package manager lock" >&2; exit 0; fi
+i=0; while fuser %s >/dev/null 2>&1; do
+  if [ $i -ge %d ]; then echo "package manager lock is busy" >&2; exit 1; fi
+  sleep 1; i=$((i+1))
+done`, strings.Join(files, " "), waitLockSeconds)
+}
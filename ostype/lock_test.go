@@ -0,0 +1,21 @@
+package ostype
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestWaitLockCommandWithoutFuser(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", waitLockCommand("/nonexistent/lock"))
+	cmd.Env = []string{"PATH=/nonexistent"}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "fuser not found") {
+		t.Fatalf("expected a warning: %q", stderr.String())
+	}
+}
@@ -0,0 +1,25 @@
+package cloudconfig
+
+import (
+	"bufio"
+	"io"
+	"os/user"
+	"strings"
+)
+
+// PasswdHomeDir returns the home directory of a user, from the content of an /etc/passwd file.
+// It returns user.UnknownUserError if the user is not found.
+// It is for BaseConfigurers that cannot use os/user, because they do not apply to the local system.
+func PasswdHomeDir(r io.Reader, username string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 6 && fields[0] == username {
+			return fields[5], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", user.UnknownUserError(username)
+}
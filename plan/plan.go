@@ -0,0 +1,150 @@
+// Package plan provides a BaseConfigurer that records operations, without applying them.
+package plan
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// Operation types
+const (
+	OpScript     = "script"
+	OpCommand    = "command"
+	OpWriteFile  = "write"
+	OpAppendFile = "append"
+	OpFileExists = "exists"
+	OpSection    = "section"
+)
+
+// Operation is a recorded BaseConfigurer call
+type Operation struct {
+	Type string `json:"type"`
+	// Args are the command args, for OpCommand
+	Args []string `json:"args,omitempty"`
+	// Script is the script input, for OpScript
+	Script string `json:"script,omitempty"`
+	// Section is the section name, for OpSection
+	Section string `json:"section,omitempty"`
+	// Path is the file path, for file operations
+	Path string `json:"path,omitempty"`
+	// Perm is the file mode, for OpWriteFile, OpAppendFile
+	Perm fs.FileMode `json:"perm,omitempty"`
+	// Data is the file content, for OpWriteFile, OpAppendFile
+	Data []byte `json:"-"`
+}
+
+// BaseConfigurer records operations in order, without touching the system.
+// FileExists returns false for files that have not been written.
+type BaseConfigurer struct {
+	Log        io.Writer
+	Operations []*Operation
+	written    map[string]struct{}
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+func (t *BaseConfigurer) add(op *Operation) {
+	t.Operations = append(t.Operations, op)
+}
+
+func (t *BaseConfigurer) BeginSection(name string) {
+	t.add(&Operation{Type: OpSection, Section: name})
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	t.add(&Operation{Type: OpScript, Script: script})
+	return nil
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	t.add(&Operation{Type: OpCommand, Args: args})
+	return nil
+}
+
+func (t *BaseConfigurer) addFile(opType string, path string, data []byte, perm fs.FileMode) {
+	t.add(&Operation{Type: opType, Path: path, Data: data, Perm: perm})
+	if t.written == nil {
+		t.written = make(map[string]struct{})
+	}
+	t.written[path] = struct{}{}
+}
+
+func (t *BaseConfigurer) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	t.addFile(OpWriteFile, path, data, perm)
+	return nil
+}
+
+func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode) error {
+	t.addFile(OpAppendFile, path, data, perm)
+	return nil
+}
+
+func (t *BaseConfigurer) FileExists(path string) (bool, error) {
+	t.add(&Operation{Type: OpFileExists, Path: path})
+	_, exists := t.written[path]
+	return exists, nil
+}
+
+// String renders an operation on a single line, except for scripts.
+func (t *Operation) String() string {
+	switch t.Type {
+	case OpScript:
+		return fmt.Sprintf("script:\n%s", indent(t.Script, "    | "))
+	case OpCommand:
+		return fmt.Sprintf("run: %s", strings.Join(quoteArgs(t.Args), " "))
+	case OpWriteFile, OpAppendFile:
+		return fmt.Sprintf("%s: %s (%04o, %d bytes)", t.Type, t.Path, t.Perm.Perm(), len(t.Data))
+	case OpFileExists:
+		return fmt.Sprintf("check exists: %s", t.Path)
+	case OpSection:
+		return fmt.Sprintf("--- %s ---", t.Section)
+	}
+	return t.Type
+}
+
+// Write renders the operations, numbered in order, under their section headers.
+func (t *BaseConfigurer) Write(w io.Writer) error {
+	var n int
+	for _, op := range t.Operations {
+		var err error
+		if op.Type == OpSection {
+			_, err = fmt.Fprintf(w, "%s\n", op.String())
+		} else {
+			n++
+			_, err = fmt.Fprintf(w, "%d. %s\n", n, op.String())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indent(s string, prefix string) string {
+	s = strings.TrimSuffix(s, "\n")
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quoteArgs quotes args that are empty or contain spaces or quotes
+func quoteArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$") {
+			quoted[i] = fmt.Sprintf("%q", arg)
+		} else {
+			quoted[i] = arg
+		}
+	}
+	return quoted
+}
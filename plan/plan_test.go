@@ -0,0 +1,51 @@
+package plan
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/ostype"
+)
+
+func TestInterfaces(t *testing.T) {
+	var _ cloudconfig.BaseConfigurer = &BaseConfigurer{}
+}
+
+func TestPlan(t *testing.T) {
+	config, err := cloudconfig.Unmarshal([]byte(`#cloud-config
+packages: [curl]
+write_files:
+- path: /etc/a
+  permissions: '0600'
+  content: abc
+users:
+- name: a
+runcmd:
+- [echo, a b]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &BaseConfigurer{}
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = &ostype.Debian{}
+	err = configurer.Apply(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	base.Write(&buf)
+	s := buf.String()
+	for _, expected := range []string{
+		"--- write_files ---\n1. run: mkdir -p /etc\n",
+		"2. write: /etc/a (0600, 3 bytes)\n",
+		"--- packages ---\n3. script:\n    | DEBIAN_FRONTEND=noninteractive apt-get -y install curl\n",
+		`run: echo "a b"`,
+	} {
+		if !strings.Contains(s, expected) {
+			t.Fatalf("missing %q in:\n%s", expected, s)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package cloudconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DefaultTailSize is the default number of bytes of output that a Step keeps.
+const DefaultTailSize = 2048
+
+// Step is an operation that Configurer performed: a command, a script, or a file write.
+type Step struct {
+	// Section is the section of the step, such as runcmd.  See the Section constants.
+	Section string `json:"section"`
+	// Index is the index of the step in its section, starting from 0.
+	Index int `json:"index"`
+	// Command describes the step, such as the command args, the first line of a script, or the file written.
+	Command string `json:"command"`
+	// Output is the tail of the combined stdout and stderr of the step.
+	Output string `json:"output,omitempty"`
+	// ExitCode is the exit code of the command, 0 if it succeeded, or -1 if it is not known.
+	ExitCode int `json:"exit_code"`
+	// Duration is the duration of the step, in nanoseconds in JSON.
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// Retried is true if the step failed and was retried.  The attempts of a step have the same index.
+	Retried bool `json:"retried,omitempty"`
+}
+
+func (t *Step) String() string {
+	return fmt.Sprintf("%s[%d] %s", t.Section, t.Index, t.Command)
+}
+
+// StepError is the error of a failed step.
+type StepError struct {
+	Step *Step
+	Err  error
+}
+
+func (t *StepError) Error() string {
+	return t.Step.String() + ": " + t.Err.Error()
+}
+
+func (t *StepError) Unwrap() error {
+	return t.Err
+}
+
+// Report records the steps of Configurer, if Configurer.Report is set.
+// To capture the output of each step, Configurer sets the log writer of its BaseConfigurer.
+// The output is also copied to Output, if it is not nil.
+type Report struct {
+	Steps []*Step `json:"steps"`
+	// Output receives the output of the commands, as it is written.
+	Output io.Writer `json:"-"`
+	// TailSize is the number of bytes of output to keep for each step.  If it is 0, DefaultTailSize is used.
+	TailSize int `json:"-"`
+	tail     []byte
+}
+
+// Write copies p to Output, and keeps its tail for the current step.
+func (t *Report) Write(p []byte) (int, error) {
+	size := t.TailSize
+	if size == 0 {
+		size = DefaultTailSize
+	}
+	t.tail = append(t.tail, p...)
+	if len(t.tail) > size {
+		t.tail = append(t.tail[:0], t.tail[len(t.tail)-size:]...)
+	}
+	if t.Output != nil {
+		return t.Output.Write(p)
+	}
+	return len(p), nil
+}
+
+// Failed returns the failed steps, except the ones that were retried.
+func (t *Report) Failed() []*Step {
+	var failed []*Step
+	for _, step := range t.Steps {
+		if step.Error != "" && !step.Retried {
+			failed = append(failed, step)
+		}
+	}
+	return failed
+}
+
+// WriteSummary writes a line for each step, and the output of failed steps.
+func (t *Report) WriteSummary(w io.Writer) error {
+	for _, step := range t.Steps {
+		status := "ok  "
+		if step.Retried {
+			status = "RTRY"
+		} else if step.Error != "" {
+			status = "FAIL"
+		}
+		_, err := fmt.Fprintf(w, "%s %s (%v)\n", status, step.String(), step.Duration.Round(time.Millisecond))
+		if err != nil {
+			return err
+		}
+		if step.Error != "" && !step.Retried {
+			fmt.Fprintf(w, "     exit code: %d, error: %s\n", step.ExitCode, step.Error)
+			if step.Output != "" {
+				fmt.Fprintf(w, "     | %s\n", strings.ReplaceAll(strings.TrimRight(step.Output, "\n"), "\n", "\n     | "))
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d steps, %d failed\n", len(t.Steps), len(t.Failed()))
+	return err
+}
+
+// exitCode returns the exit code of a command error,
+// for errors that have an ExitCode() method, such as exec.ExitError,
+// or an ExitStatus() method, such as ssh.ExitError.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitCoder interface{ ExitCode() int }
+	if errors.As(err, &exitCoder) {
+		return exitCoder.ExitCode()
+	}
+	var exitStatuser interface{ ExitStatus() int }
+	if errors.As(err, &exitStatuser) {
+		return exitStatuser.ExitStatus()
+	}
+	return -1
+}
+
+// firstLine returns the first non-empty line of a script.
+func firstLine(script string) string {
+	for _, line := range strings.Split(script, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package cloudconfig
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy specifies how to retry a failed step, with exponential backoff.
+// The zero value does not retry.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts.  0 or 1 means no retries.
+	Attempts int
+	// Delay is the delay before the first retry.  It doubles after each retry.
+	Delay time.Duration
+	// MaxDelay limits the delay.  0 means no limit.
+	MaxDelay time.Duration
+}
+
+// delay returns the delay after a failed attempt, starting from 1.
+func (t RetryPolicy) delay(attempt int) time.Duration {
+	delay := t.Delay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if t.MaxDelay > 0 && delay >= t.MaxDelay {
+			break
+		}
+	}
+	if t.MaxDelay > 0 && delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay
+}
+
+// retry calls run until it succeeds, up to policy.Attempts times.
+// The steps of each attempt have the same indexes,
+// and the failed steps of all but the last attempt are marked as retried.
+func (t *Configurer) retry(ctx context.Context, policy RetryPolicy, run func() error) error {
+	stepIndex := t.stepIndex
+	for attempt := 1; ; attempt++ {
+		last := attempt >= policy.Attempts
+		t.stepIndex = stepIndex
+		t.retrying = !last
+		err := run()
+		t.retrying = false
+		if err == nil || last || ctx.Err() != nil {
+			return err
+		}
+		delay := policy.delay(attempt)
+		t.logf("attempt %d of %d failed: %v.  retrying in %v\n", attempt, policy.Attempts, err, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
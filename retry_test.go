@@ -0,0 +1,16 @@
+package cloudconfig
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{Attempts: 10, Delay: time.Second, MaxDelay: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if d := policy.delay(i + 1); d != delay {
+			t.Errorf("attempt %d: %v, expected %v", i+1, d, delay)
+		}
+	}
+}
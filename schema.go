@@ -0,0 +1,168 @@
+package cloudconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SchemaURI is the JSON Schema dialect of JSONSchema
+const SchemaURI = "https://json-schema.org/draft/2020-12/schema"
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// JSONSchema returns a JSON Schema for the subset of cloud-config that is supported.
+// It is generated from the Config, File and User types, so it follows them.
+// Unknown keys are not allowed by the schema, although Unmarshal preserves them.
+func JSONSchema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	schema := g.schema(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = SchemaURI
+	schema["title"] = "cloud-config"
+	schema["$defs"] = g.defs
+	return schema
+}
+
+// MarshalJSONSchema returns JSONSchema() as indented JSON.
+func MarshalJSONSchema() ([]byte, error) {
+	data, err := json.MarshalIndent(JSONSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (t *schemaGenerator) schema(typ reflect.Type, key string) map[string]any {
+	if typ == reflect.TypeOf(Commands{}) {
+		return commandsSchema()
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		return t.schema(typ.Elem(), key)
+	case reflect.Struct:
+		if typ == reflect.TypeOf(Config{}) {
+			return t.structSchema(typ)
+		}
+		name := strings.ToLower(typ.Name())
+		if _, exists := t.defs[name]; !exists {
+			t.defs[name] = nil
+			t.defs[name] = t.structSchema(typ)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": t.schema(typ.Elem(), key)}
+	case reflect.String:
+		return stringSchema(key)
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Interface:
+		return anySchema(key)
+	}
+	return map[string]any{}
+}
+
+func (t *schemaGenerator) structSchema(typ reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key := yamlKey(field)
+		if key != "" {
+			properties[key] = t.schema(field.Type, key)
+		}
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	switch typ {
+	case reflect.TypeOf(File{}):
+		schema["required"] = []string{"path"}
+	case reflect.TypeOf(User{}):
+		schema["required"] = []string{"name"}
+	}
+	return schema
+}
+
+func stringSchema(key string) map[string]any {
+	schema := map[string]any{"type": "string"}
+	switch key {
+	case "permissions":
+		// permissions: 0644 is a YAML integer, and 420 a JSON integer, which the model accepts.
+		// The pattern applies only to strings.
+		schema["type"] = []string{"string", "integer"}
+		schema["pattern"] = "^[0-7]+$"
+	case "uid":
+		schema["type"] = []string{"string", "integer"}
+	case "owner":
+		schema["pattern"] = "^[^:]+:[^:]+$"
+	case "path", "name":
+		schema["minLength"] = 1
+	}
+	return schema
+}
+
+func commandsSchema() map[string]any {
+	command := []any{
+		map[string]any{"type": "string", "minLength": 1},
+		map[string]any{
+			"type":     "array",
+			"minItems": 1,
+			"items":    map[string]any{"type": []string{"string", "number", "boolean"}},
+		},
+	}
+	str := map[string]any{"type": "string"}
+	commandMap := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			CommandKeyCmd:   map[string]any{"oneOf": command},
+			CommandKeyUser:  str,
+			CommandKeyDir:   str,
+			CommandKeyStdin: str,
+			CommandKeyEnv: map[string]any{
+				"type":                 "object",
+				"additionalProperties": map[string]any{"type": []string{"string", "number", "boolean"}},
+			},
+		},
+		"required":             []string{CommandKeyCmd},
+		"additionalProperties": false,
+	}
+	return map[string]any{
+		"type": "array",
+		"items": map[string]any{
+			"oneOf": append(command, commandMap),
+		},
+	}
+}
+
+func anySchema(key string) map[string]any {
+	switch key {
+	case "sudo":
+		return map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": []string{"boolean", "string", "null"}},
+				map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			},
+		}
+	case "merge_how", "merge_type":
+		return map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"name":     map[string]any{"enum": []string{"dict", "list", "str"}},
+							"settings": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+						},
+						"required": []string{"name"},
+					},
+				},
+			},
+		}
+	}
+	return map[string]any{}
+}
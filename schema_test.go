@@ -0,0 +1,68 @@
+package cloudconfig
+
+import (
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema()
+	properties := schema["properties"].(map[string]any)
+	for _, key := range []string{"packages", "write_files", "users", "runcmd", "timezone"} {
+		if _, exists := properties[key]; !exists {
+			t.Errorf("missing %s", key)
+		}
+	}
+	defs := schema["$defs"].(map[string]any)
+	file := defs["file"].(map[string]any)["properties"].(map[string]any)
+	if _, exists := file["defer"]; !exists {
+		t.Errorf("missing file.defer")
+	}
+	runcmd := properties["runcmd"].(map[string]any)["items"].(map[string]any)["oneOf"].([]any)
+	if len(runcmd) != 3 {
+		t.Errorf("runcmd: expected string, list, or map forms: %v", runcmd)
+	}
+	if _, err := MarshalJSONSchema(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONSchemaUnquotedIntegers(t *testing.T) {
+	data := []byte(`#cloud-config
+write_files:
+- path: /etc/a
+  permissions: 0644
+users:
+- name: a
+  uid: 1000
+`)
+	c, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Users[0].Uid != "1000" {
+		t.Fatalf("uid: %s", c.Users[0].Uid)
+	}
+	if HasErrors(Validate(data)) {
+		t.Fatalf("%v", Validate(data))
+	}
+	defs := JSONSchema()["$defs"].(map[string]any)
+	for _, p := range []struct{ def, key string }{{"file", "permissions"}, {"user", "uid"}} {
+		property := defs[p.def].(map[string]any)["properties"].(map[string]any)[p.key].(map[string]any)
+		types, _ := property["type"].([]string)
+		if len(types) != 2 || types[0] != "string" || types[1] != "integer" {
+			t.Errorf("%s.%s: %v", p.def, p.key, property["type"])
+		}
+	}
+}
+
+func TestJSONSchemaIntegerExample(t *testing.T) {
+	// integers are valid for permissions and uid in the schema, so the model must parse them from JSON too.
+	data := []byte(`{"write_files": [{"path": "/etc/a", "permissions": 384}], "users": [{"name": "a", "uid": 1000}]}`)
+	c, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Files[0].Permissions != "0600" || c.Users[0].Uid != "1000" {
+		t.Fatalf("%v %v", c.Files[0], c.Users[0])
+	}
+}
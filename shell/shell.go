@@ -0,0 +1,114 @@
+// Package shell provides a BaseConfigurer that writes a standalone POSIX shell script,
+// instead of applying a configuration.
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"melato.org/cloudconfig"
+)
+
+// Delimiter is the heredoc delimiter used for file contents and scripts.
+// A number is appended to it, if the content has a line equal to it.
+const Delimiter = "CLOUDCONFIG_EOF"
+
+// BaseConfigurer writes each operation as shell commands to Script.
+// FileExists always returns false, so files such as authorized_keys
+// are written unconditionally by the script.
+type BaseConfigurer struct {
+	Script bytes.Buffer
+	Log    io.Writer
+}
+
+// NewBaseConfigurer creates a BaseConfigurer and writes the script header.
+func NewBaseConfigurer() *BaseConfigurer {
+	t := &BaseConfigurer{}
+	fmt.Fprintf(&t.Script, "#!/bin/sh\n# generated by cloudconfig\nset -e\n")
+	return t
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+func (t *BaseConfigurer) BeginSection(name string) {
+	fmt.Fprintf(&t.Script, "\n# --- %s ---\n", name)
+}
+
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = cloudconfig.ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// HeredocDelimiter returns a heredoc delimiter that does not appear as a line in content.
+// It is Delimiter, followed by a number, if necessary.
+func HeredocDelimiter(content string) string {
+	d := Delimiter
+	for i := 1; ; i++ {
+		if !strings.Contains("\n"+content+"\n", "\n"+d+"\n") {
+			return d
+		}
+		d = fmt.Sprintf("%s%d", Delimiter, i)
+	}
+}
+
+// heredoc writes command, followed by content as a quoted heredoc.
+// If content does not end with a newline, it is written with printf instead.
+func (t *BaseConfigurer) heredoc(command string, redirect string, content string) {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		fmt.Fprintf(&t.Script, "printf '%%s' %s | %s%s\n", cloudconfig.ShellQuote(content), command, redirect)
+		return
+	}
+	d := HeredocDelimiter(content)
+	fmt.Fprintf(&t.Script, "%s%s <<'%s'\n%s%s\n", command, redirect, d, content, d)
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	t.heredoc("sh", "", script)
+	return nil
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	fmt.Fprintf(&t.Script, "%s\n", quoteArgs(args))
+	return nil
+}
+
+func (t *BaseConfigurer) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	t.heredoc("cat", " > "+cloudconfig.ShellQuote(path), string(data))
+	fmt.Fprintf(&t.Script, "chmod %04o %s\n", perm.Perm(), cloudconfig.ShellQuote(path))
+	return nil
+}
+
+// AppendFile sets the permissions only if it creates the file, like local.BaseConfigurer.
+func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode) error {
+	fmt.Fprintf(&t.Script, "if [ ! -e %s ]; then : > %s; chmod %04o %s; fi\n",
+		cloudconfig.ShellQuote(path), cloudconfig.ShellQuote(path), perm.Perm(), cloudconfig.ShellQuote(path))
+	t.heredoc("cat", " >> "+cloudconfig.ShellQuote(path), string(data))
+	return nil
+}
+
+func (t *BaseConfigurer) FileExists(path string) (bool, error) {
+	return false, nil
+}
+
+// Export returns a shell script that applies user-data, using the given OS type.
+func Export(u *cloudconfig.UserData, os cloudconfig.OSType) ([]byte, error) {
+	base := NewBaseConfigurer()
+	configurer := cloudconfig.NewConfigurer(base)
+	configurer.OS = os
+	err := configurer.ApplyUserData(u)
+	if err != nil {
+		return nil, err
+	}
+	return base.Script.Bytes(), nil
+}
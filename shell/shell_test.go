@@ -0,0 +1,56 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"melato.org/cloudconfig"
+)
+
+func TestInterfaces(t *testing.T) {
+	var _ cloudconfig.BaseConfigurer = &BaseConfigurer{}
+	var _ cloudconfig.BaseSection = &BaseConfigurer{}
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b c")
+	config := &cloudconfig.Config{
+		Files: []*cloudconfig.File{
+			{Path: a, Content: "x\n" + Delimiter + "\n", Permissions: "0600"},
+			{Path: a, Content: "y", Append: true},
+			{Path: b, Content: "it's $HOME", Defer: true},
+		},
+		Runcmd: cloudconfig.Commands{"echo run >> " + a},
+	}
+	script, err := Export(&cloudconfig.UserData{Config: config}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(script)
+	for _, section := range []string{"# --- write_files ---", "# --- write_files_deferred ---", "# --- runcmd ---"} {
+		if !strings.Contains(s, section) {
+			t.Fatalf("missing %s:\n%s", section, s)
+		}
+	}
+	out, err := exec.Command("sh", "-c", s).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s\n%s", err, string(out), s)
+	}
+	data, _ := os.ReadFile(a)
+	if string(data) != "x\n"+Delimiter+"\nyrun\n" {
+		t.Fatalf("%q", string(data))
+	}
+	st, _ := os.Stat(a)
+	if st.Mode().Perm() != 0600 {
+		t.Fatalf("%v", st.Mode())
+	}
+	data, _ = os.ReadFile(b)
+	if string(data) != "it's $HOME" {
+		t.Fatalf("%q", string(data))
+	}
+}
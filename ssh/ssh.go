@@ -0,0 +1,266 @@
+// Package ssh provides a BaseConfigurer that applies a config to a remote host over SSH.
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"melato.org/cloudconfig"
+)
+
+// BaseConfigurer runs commands and scripts in SSH sessions.
+// Files are transferred through the stdin of a remote shell,
+// so the remote host needs sh and cat, but not SFTP.
+// Commands run as the SSH user, which should normally be root.
+type BaseConfigurer struct {
+	Client *gossh.Client
+	Log    io.Writer
+}
+
+// NewBaseConfigurer creates a BaseConfigurer that uses an SSH client.
+func NewBaseConfigurer(client *gossh.Client) *BaseConfigurer {
+	return &BaseConfigurer{Client: client}
+}
+
+func (t *BaseConfigurer) SetLogWriter(w io.Writer) {
+	t.Log = w
+}
+
+// run runs a shell command in a new session, with the given stdin.
+// If stdout is nil, the output goes to Log.
+func (t *BaseConfigurer) run(command string, stdin io.Reader, stdout io.Writer) error {
+	return t.runContext(context.Background(), command, stdin, stdout)
+}
+
+// runContext is like run, but when ctx is done, it sends KILL to the command and closes the session.
+// Servers that do not support signals stop the command when the session closes, if it writes output.
+func (t *BaseConfigurer) runContext(ctx context.Context, command string, stdin io.Reader, stdout io.Writer) error {
+	session, err := t.Client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = stdin
+	var log io.Writer
+	if t.Log != nil {
+		log = &syncWriter{w: t.Log}
+	}
+	if stdout != nil {
+		session.Stdout = stdout
+	} else {
+		session.Stdout = log
+	}
+	session.Stderr = log
+	err = session.Start(command)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(gossh.SIGKILL)
+		session.Close()
+		return ctx.Err()
+	}
+}
+
+// syncWriter serializes writes to w.
+// A session copies stdout and stderr in separate goroutines,
+// so they need a syncWriter when they go to the same writer.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *syncWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.w.Write(p)
+}
+
+func (t *BaseConfigurer) RunCommand(args ...string) error {
+	return t.RunCommandContext(context.Background(), args...)
+}
+
+func (t *BaseConfigurer) RunCommandContext(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command has 0 args")
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = cloudconfig.ShellQuote(arg)
+	}
+	return t.runContext(ctx, strings.Join(quoted, " "), nil, nil)
+}
+
+func (t *BaseConfigurer) RunScript(script string) error {
+	return t.RunScriptContext(context.Background(), script)
+}
+
+func (t *BaseConfigurer) RunScriptContext(ctx context.Context, script string) error {
+	return t.runContext(ctx, "/bin/sh", strings.NewReader(script), nil)
+}
+
+// createCommand returns a shell command that creates a file with the given permissions, if it does not exist.
+func createCommand(path string, perm fs.FileMode) string {
+	q := cloudconfig.ShellQuote(path)
+	return fmt.Sprintf("if [ ! -e %s ]; then : > %s; chmod %04o %s; fi", q, q, perm.Perm(), q)
+}
+
+func (t *BaseConfigurer) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	command := createCommand(path, perm) + " && cat > " + cloudconfig.ShellQuote(path)
+	return t.run(command, bytes.NewReader(data), nil)
+}
+
+func (t *BaseConfigurer) AppendFile(path string, data []byte, perm fs.FileMode) error {
+	command := createCommand(path, perm) + " && cat >> " + cloudconfig.ShellQuote(path)
+	return t.run(command, bytes.NewReader(data), nil)
+}
+
+func (t *BaseConfigurer) FileExists(path string) (bool, error) {
+	err := t.run("test -e "+cloudconfig.ShellQuote(path), nil, nil)
+	if err == nil {
+		return true, nil
+	}
+	var exitError *gossh.ExitError
+	if errors.As(err, &exitError) && exitError.ExitStatus() == 1 {
+		return false, nil
+	}
+	return false, err
+}
+
+// UserHomeDir looks up a user's home directory in the remote /etc/passwd.
+func (t *BaseConfigurer) UserHomeDir(username string) (string, error) {
+	var buf bytes.Buffer
+	err := t.run("cat /etc/passwd", nil, &buf)
+	if err != nil {
+		return "", err
+	}
+	return cloudconfig.PasswdHomeDir(&buf, username)
+}
+
+// Target is the destination of an SSH connection, parsed from [user@]host[:port].
+type Target struct {
+	User string
+	// Addr is host:port
+	Addr string
+}
+
+// ParseTarget parses [user@]host[:port].  The default user is root and the default port is 22.
+func ParseTarget(s string) Target {
+	target := Target{User: "root"}
+	if user, host, found := strings.Cut(s, "@"); found {
+		target.User = user
+		s = host
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(strings.Trim(s, "[]"), "22")
+	}
+	target.Addr = s
+	return target
+}
+
+// Auth specifies how to authenticate to the host, and how to verify the host key.
+type Auth struct {
+	// KeyFiles are private key files.
+	KeyFiles []string
+	// NoAgent disables authentication with the SSH agent of SSH_AUTH_SOCK.
+	NoAgent bool
+	// KnownHosts are known_hosts files.
+	// If empty, ~/.ssh/known_hosts is used.
+	KnownHosts []string
+}
+
+// ClientConfig creates an SSH client configuration, for the given user.
+// It uses the SSH agent, if SSH_AUTH_SOCK is set, and the key files.
+// Host keys are verified with the known_hosts files.
+// The agent signs during the handshake, so ClientConfig also returns the agent connection,
+// which the caller should close after the handshake.  It is nil if the agent is not used.
+func (t *Auth) ClientConfig(user string) (*gossh.ClientConfig, io.Closer, error) {
+	var methods []gossh.AuthMethod
+	var agentConn io.Closer
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" && !t.NoAgent {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ssh agent: %w", err)
+		}
+		agentConn = conn
+		methods = append(methods, gossh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	config, err := t.clientConfig(user, methods)
+	if err != nil {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, nil, err
+	}
+	return config, agentConn, nil
+}
+
+// clientConfig adds the key files to the agent auth methods, and creates the configuration.
+func (t *Auth) clientConfig(user string, methods []gossh.AuthMethod) (*gossh.ClientConfig, error) {
+	for _, file := range t.KeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := gossh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		methods = append(methods, gossh.PublicKeys(signer))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh authentication method.  Use an ssh agent or a key file")
+	}
+	knownHosts := t.KnownHosts
+	if len(knownHosts) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+	hostKeyCallback, err := knownhosts.New(knownHosts...)
+	if err != nil {
+		return nil, err
+	}
+	return &gossh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+// Dial connects to an SSH target, [user@]host[:port], and returns a BaseConfigurer for it.
+// The caller should close the Client when done.
+func (t *Auth) Dial(target string) (*BaseConfigurer, error) {
+	tg := ParseTarget(target)
+	config, agentConn, err := t.ClientConfig(tg.User)
+	if err != nil {
+		return nil, err
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+	client, err := gossh.Dial("tcp", tg.Addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tg.Addr, err)
+	}
+	return NewBaseConfigurer(client), nil
+}
@@ -0,0 +1,315 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"melato.org/cloudconfig"
+	"melato.org/cloudconfig/cloudconfigtest"
+)
+
+func TestInterfaces(t *testing.T) {
+	base := &BaseConfigurer{}
+	var _ cloudconfig.BaseConfigurer = base
+	var _ cloudconfig.BaseUserHomeDir = base
+	var _ cloudconfig.BaseContext = base
+}
+
+func TestParseTarget(t *testing.T) {
+	cases := []struct{ s, user, addr string }{
+		{"host", "root", "host:22"},
+		{"a@host:2222", "a", "host:2222"},
+		{"[::1]", "root", "[::1]:22"},
+	}
+	for _, c := range cases {
+		target := ParseTarget(c.s)
+		if target.User != c.user || target.Addr != c.addr {
+			t.Errorf("%s: %v", c.s, target)
+		}
+	}
+}
+
+func newSigner(t *testing.T) gossh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// testServer is an in-process SSH server that runs exec requests with the local sh.
+type testServer struct {
+	listener net.Listener
+	hostKey  gossh.Signer
+}
+
+func newTestServer(t *testing.T, clientKey gossh.PublicKey) *testServer {
+	hostKey := newSigner(t)
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+	return &testServer{listener: listener, hostKey: hostKey}
+}
+
+func serveConn(conn net.Conn, config *gossh.ServerConfig) {
+	_, channels, requests, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go gossh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(gossh.UnknownChannelType, "")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+func serveSession(channel gossh.Channel, requests <-chan *gossh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" || len(req.Payload) < 4 {
+			req.Reply(false, nil)
+			continue
+		}
+		command := string(req.Payload[4:])
+		req.Reply(true, nil)
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = channel
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		var status uint32
+		if err := cmd.Run(); err != nil {
+			status = 255
+			if exitError, ok := err.(*exec.ExitError); ok {
+				status = uint32(exitError.ExitCode())
+			}
+		}
+		channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+		return
+	}
+}
+
+func (t *testServer) knownHosts(test *testing.T) string {
+	file := filepath.Join(test.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(t.listener.Addr().String())}, t.hostKey.PublicKey())
+	err := os.WriteFile(file, []byte(line+"\n"), 0600)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return file
+}
+
+func writeKey(t *testing.T) (string, gossh.PublicKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "id_ed25519")
+	err = os.WriteFile(file, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, signer.PublicKey()
+}
+
+func TestBaseConfigurer(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile, publicKey := writeKey(t)
+	server := newTestServer(t, publicKey)
+	auth := &Auth{KeyFiles: []string{keyFile}, KnownHosts: []string{server.knownHosts(t)}}
+	base, err := auth.Dial("u@" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Client.Close()
+	var log strings.Builder
+	base.SetLogWriter(&log)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a b")
+	exists, err := base.FileExists(file)
+	if err != nil || exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+	err = base.WriteFile(file, []byte("a\n"), 0600)
+	if err == nil {
+		err = base.AppendFile(file, []byte("b\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "a\nb\n" {
+		t.Fatalf("%v %q", err, string(data))
+	}
+	st, err := os.Stat(file)
+	if err != nil || st.Mode().Perm() != 0600 {
+		t.Fatalf("%v %v", err, st.Mode())
+	}
+	exists, err = base.FileExists(file)
+	if err != nil || !exists {
+		t.Fatalf("%v %v", exists, err)
+	}
+	err = base.RunCommand("echo", "it's")
+	if err == nil {
+		err = base.RunScript("echo script\n")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.String() != "it's\nscript\n" {
+		t.Fatalf("%q", log.String())
+	}
+	if base.RunCommand("false") == nil {
+		t.Fatalf("false should fail")
+	}
+}
+
+func TestUnknownHost(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile, publicKey := writeKey(t)
+	server := newTestServer(t, publicKey)
+	other := newTestServer(t, publicKey)
+	auth := &Auth{KeyFiles: []string{keyFile}, KnownHosts: []string{other.knownHosts(t)}}
+	_, err := auth.Dial(server.listener.Addr().String())
+	if err == nil {
+		t.Fatalf("expected host key error")
+	}
+}
+
+func TestAgent(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	err = keyring.Add(agent.AddedKey{PrivateKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	served := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				served <- struct{}{}
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, signer.PublicKey())
+	auth := &Auth{KnownHosts: []string{server.knownHosts(t)}}
+	base, err := auth.Dial(server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Client.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the agent connection was not closed")
+	}
+	if err := base.RunCommand("true"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile, publicKey := writeKey(t)
+	server := newTestServer(t, publicKey)
+	auth := &Auth{KeyFiles: []string{keyFile}, KnownHosts: []string{server.knownHosts(t)}}
+	cloudconfigtest.RunConformance(t, func(t *testing.T) (cloudconfig.BaseConfigurer, string) {
+		base, err := auth.Dial(server.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { base.Client.Close() })
+		return base, t.TempDir()
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile, publicKey := writeKey(t)
+	server := newTestServer(t, publicKey)
+	auth := &Auth{KeyFiles: []string{keyFile}, KnownHosts: []string{server.knownHosts(t)}}
+	base, err := auth.Dial(server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = base.RunCommandContext(ctx, "sleep", "5")
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 3*time.Second {
+		t.Fatalf("%v", err)
+	}
+}
@@ -11,9 +11,12 @@ const (
 	DoasDir    = "/etc/doas.d"
 )
 
+// DefaultSudoRule is the sudoers rule for sudo: true.
+const DefaultSudoRule = "ALL=(ALL) NOPASSWD:ALL"
+
 func sudoScript(user string, values []string) string {
 	if len(values) == 0 {
-		values = []string{"ALL=(ALL) NOPASSWD:ALL"}
+		values = []string{DefaultSudoRule}
 	}
 	var buf bytes.Buffer
 	file := filepath.Join(SudoersDir, user)
@@ -0,0 +1,289 @@
+package cloudconfig
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// user-data part content types
+const (
+	TypeCloudConfig = "text/cloud-config"
+	TypeShellScript = "text/x-shellscript"
+	TypeBoothook    = "text/cloud-boothook"
+	TypeInclude     = "text/x-include-url"
+	TypeIncludeOnce = "text/x-include-once-url"
+	TypePlain       = "text/plain"
+	TypeGzip        = "application/x-gzip"
+)
+
+// Script is a user-data shell script or boothook.
+type Script struct {
+	// Filename is the part filename, if any.
+	Filename string
+	Content  string
+}
+
+// UserData is the result of parsing cloud-init user-data.
+type UserData struct {
+	// Config is the merge of all cloud-config parts, in the order in which they appear.
+	Config *Config
+	// Boothooks are run before the config is applied.
+	Boothooks []*Script
+	// Scripts are run after the config is applied, in the order in which they appear.
+	Scripts []*Script
+}
+
+// UserDataReader parses user-data in any of the supported formats:
+//   - #cloud-config documents
+//   - JSON cloud-config documents
+//   - #cloud-config-archive documents
+//   - #! shell scripts
+//   - #include and #include-once URL lists
+//   - #cloud-boothook scripts
+//   - MIME multipart documents with any of the above as parts
+//   - gzip-compressed content of any of the above
+type UserDataReader struct {
+	// Fetcher fetches #include URLs.  If it is nil, #include is not supported.
+	Fetcher Fetcher
+	// included records #include-once URLs that have been fetched.
+	included map[string]bool
+}
+
+type userDataParser struct {
+	reader    *UserDataReader
+	depth     int
+	configs   []*Config
+	boothooks []*Script
+	scripts   []*Script
+}
+
+// Parse detects the format of user-data and parses it.
+func (t *UserDataReader) Parse(data []byte) (*UserData, error) {
+	p := &userDataParser{reader: t}
+	err := p.handlePart(TypePlain, "", "", data)
+	if err != nil {
+		return nil, err
+	}
+	return p.userData()
+}
+
+// ReadFile reads and parses a user-data file.
+func (t *UserDataReader) ReadFile(file string) (*UserData, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	u, err := t.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return u, nil
+}
+
+// ParseUserData parses user-data without #include support.  See UserDataReader.
+func ParseUserData(data []byte) (*UserData, error) {
+	var reader UserDataReader
+	return reader.Parse(data)
+}
+
+// ReadUserData reads a user-data file without #include support.  See UserDataReader.
+func ReadUserData(file string) (*UserData, error) {
+	var reader UserDataReader
+	return reader.ReadFile(file)
+}
+
+func (t *userDataParser) userData() (*UserData, error) {
+	config, err := Merge(t.configs...)
+	if err != nil {
+		return nil, err
+	}
+	return &UserData{Config: config, Boothooks: t.boothooks, Scripts: t.scripts}, nil
+}
+
+func isMime(data []byte) bool {
+	line, _, _ := bufio.NewReader(bytes.NewReader(data)).ReadLine()
+	s := strings.ToLower(string(line))
+	return strings.HasPrefix(s, "content-type:") || strings.HasPrefix(s, "mime-version:")
+}
+
+func isGzip(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// MaxGunzipSize limits the size of decompressed gzip user-data.
+// It protects against small gzip files that decompress to a lot of data.
+const MaxGunzipSize = 16 << 20
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err = io.ReadAll(io.LimitReader(r, MaxGunzipSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxGunzipSize {
+		return nil, fmt.Errorf("gzip: decompressed size exceeds %d bytes", MaxGunzipSize)
+	}
+	return data, nil
+}
+
+func (t *userDataParser) parseMime(data []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return t.parseEntity(textproto.MIMEHeader(msg.Header), "", msg.Body)
+}
+
+// parseEntity parses a MIME message or part
+func (t *userDataParser) parseEntity(header textproto.MIMEHeader, filename string, body io.Reader) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = TypePlain
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%s: %w", contentType, err)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = t.parseEntity(part.Header, part.FileName(), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	mergeType := header.Get("Merge-Type")
+	if mergeType == "" {
+		mergeType = header.Get("X-Merge-Type")
+	}
+	return t.handlePart(mediaType, filename, mergeType, content)
+}
+
+// handlePart handles a user-data part according to its type.
+// mergeType is applied to cloud-config parts that do not specify merge_how.
+func (t *userDataParser) handlePart(mediaType, filename, mergeType string, content []byte) error {
+	if mediaType == TypeGzip || mediaType == "application/gzip" || isGzip(content) {
+		data, err := gunzip(content)
+		if err != nil {
+			return partError(filename, err)
+		}
+		return t.handlePart(sniffType(data), filename, mergeType, data)
+	}
+	if mediaType == TypePlain {
+		mediaType = sniffType(content)
+	}
+	switch mediaType {
+	case TypeCloudConfig:
+		config, err := unmarshalConfig(content)
+		if err != nil {
+			return partError(filename, err)
+		}
+		if config.MergeHow == nil && config.MergeType == nil && mergeType != "" {
+			config.MergeHow = mergeType
+		}
+		t.configs = append(t.configs, config)
+	case TypeShellScript:
+		t.scripts = append(t.scripts, &Script{Filename: filename, Content: string(content)})
+	case TypeBoothook:
+		t.boothooks = append(t.boothooks, &Script{Filename: filename, Content: stripBoothook(content)})
+	case TypeArchive:
+		return t.archive(content)
+	case TypeInclude:
+		return t.include(content, false)
+	case TypeIncludeOnce:
+		return t.include(content, true)
+	case "message/rfc822":
+		return t.parseMime(content)
+	case TypePlain:
+		return partError(filename, fmt.Errorf("unrecognized user-data format"))
+	default:
+		return partError(filename, fmt.Errorf("unsupported user-data type: %s", mediaType))
+	}
+	return nil
+}
+
+func partError(filename string, err error) error {
+	if filename == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", filename, err)
+}
+
+// sniffType determines the type of user-data content from its first line
+func sniffType(data []byte) string {
+	switch {
+	case HasComment(data), IsJSON(data):
+		return TypeCloudConfig
+	case FirstLineIs(data, ArchiveComment):
+		return TypeArchive
+	case bytes.HasPrefix(data, []byte("#cloud-boothook")):
+		return TypeBoothook
+	case bytes.HasPrefix(data, []byte("#include-once")):
+		return TypeIncludeOnce
+	case bytes.HasPrefix(data, []byte("#include")):
+		return TypeInclude
+	case bytes.HasPrefix(data, []byte("#!")):
+		return TypeShellScript
+	case isMime(data):
+		return "message/rfc822"
+	}
+	return TypePlain
+}
+
+// stripBoothook removes the #cloud-boothook line
+func stripBoothook(data []byte) string {
+	s := string(data)
+	if strings.HasPrefix(s, "#cloud-boothook") {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			return ""
+		}
+		return s[i+1:]
+	}
+	return s
+}
+
+// unmarshalConfig unmarshals a cloud-config part, which does not need to start with Comment.
+func unmarshalConfig(data []byte) (*Config, error) {
+	if IsJSON(data) {
+		return unmarshalJSON(data)
+	}
+	var config Config
+	err := yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
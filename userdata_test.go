@@ -0,0 +1,184 @@
+package cloudconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func gzipBase64(s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestParseUserDataMultipart(t *testing.T) {
+	script := "#!/bin/sh\necho b\n"
+	data := fmt.Sprintf(`Content-Type: multipart/mixed; boundary="XYZ"
+MIME-Version: 1.0
+
+--XYZ
+Content-Type: text/cloud-config; charset="us-ascii"
+Content-Disposition: attachment; filename="a.yaml"
+
+#cloud-config
+packages:
+- a
+--XYZ
+Content-Type: text/x-shellscript
+Content-Transfer-Encoding: base64
+Content-Disposition: attachment; filename="b.sh"
+
+%s
+--XYZ
+Content-Type: text/cloud-boothook
+
+#cloud-boothook
+echo boot
+--XYZ
+Content-Type: application/x-gzip
+Content-Transfer-Encoding: base64
+
+%s
+--XYZ--
+`, base64.StdEncoding.EncodeToString([]byte(script)),
+		gzipBase64("#cloud-config\ntimezone: UTC\n"))
+	u, err := ParseUserData([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"a"}, u.Config.Packages) || u.Config.Timezone != "UTC" {
+		t.Fatalf("%v", u.Config)
+	}
+	if len(u.Scripts) != 1 || u.Scripts[0].Content != script || u.Scripts[0].Filename != "b.sh" {
+		t.Fatalf("scripts: %v", u.Scripts)
+	}
+	if len(u.Boothooks) != 1 || u.Boothooks[0].Content != "echo boot" {
+		t.Fatalf("boothooks: %v", u.Boothooks)
+	}
+}
+
+type mapFetcher map[string]string
+
+func (t mapFetcher) Fetch(url string) ([]byte, error) {
+	s, exists := t[url]
+	if !exists {
+		return nil, fmt.Errorf("not found: %s", url)
+	}
+	return []byte(s), nil
+}
+
+func TestParseUserDataInclude(t *testing.T) {
+	reader := &UserDataReader{Fetcher: mapFetcher{
+		"http://x/a": "#cloud-config\npackages: [a]\n",
+		"http://x/b": "#!/bin/sh\necho b\n",
+	}}
+	data := "#include\n# comment\nhttp://x/a\n\nhttp://x/b\n"
+	u, err := reader.Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"a"}, u.Config.Packages) || len(u.Scripts) != 1 {
+		t.Fatalf("%v %v", u.Config, u.Scripts)
+	}
+	u, err = reader.Parse([]byte("#include-once\nhttp://x/b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = reader.Parse([]byte("#include-once\nhttp://x/b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Scripts) != 0 {
+		t.Fatalf("#include-once fetched twice")
+	}
+	_, err = ParseUserData([]byte(data))
+	if err == nil {
+		t.Fatalf("#include should fail without a fetcher")
+	}
+}
+
+func TestParseUserDataFormats(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("#cloud-config\ntimezone: UTC\n"))
+	w.Close()
+	u, err := ParseUserData(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Config.Timezone != "UTC" {
+		t.Fatalf("%v", u.Config)
+	}
+	u, err = ParseUserData([]byte("#cloud-boothook\necho a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Boothooks) != 1 || u.Boothooks[0].Content != "echo a\n" {
+		t.Fatalf("%v", u.Boothooks)
+	}
+	_, err = ParseUserData([]byte("packages: [a]\n"))
+	if err == nil {
+		t.Fatalf("should not accept a document without a header")
+	}
+}
+
+func TestParseUserDataArchive(t *testing.T) {
+	data := `#cloud-config-archive
+- type: text/cloud-config
+  content: |
+    packages: [a]
+- content: |
+    #!/bin/sh
+    echo a
+- "#cloud-boothook\necho b\n"
+- content: |
+    timezone: UTC
+`
+	u, err := ParseUserData([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceEquals([]string{"a"}, u.Config.Packages) || u.Config.Timezone != "UTC" {
+		t.Fatalf("%v", u.Config)
+	}
+	if len(u.Scripts) != 1 || len(u.Boothooks) != 1 {
+		t.Fatalf("%v %v", u.Scripts, u.Boothooks)
+	}
+}
+
+func TestHTTPFetcherTimeout(t *testing.T) {
+	if defaultFetchClient.Timeout == 0 {
+		t.Fatalf("the default fetch client has no timeout")
+	}
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	fetcher := &HTTPFetcher{Client: &http.Client{Timeout: 50 * time.Millisecond}}
+	_, err := fetcher.Fetch(server.URL)
+	if err == nil {
+		t.Fatalf("expected timeout")
+	}
+}
+
+func TestGunzipLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("#cloud-config\n"))
+	w.Write(make([]byte, MaxGunzipSize))
+	w.Close()
+	_, err := ParseUserData(buf.Bytes())
+	if err == nil {
+		t.Fatalf("should not accept gzip data larger than MaxGunzipSize")
+	}
+}
@@ -2,6 +2,7 @@ package cloudconfig
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -59,14 +60,12 @@ func CommandArgs(command any) ([]string, bool) {
 	}
 }
 
-func toStrings(a any) ([]string, error) {
+// ToStrings converts a string or a list of strings, such as User.Sudo, to a []string.
+// A bool converts to an empty list.
+func ToStrings(a any) ([]string, error) {
 	switch v := a.(type) {
 	case bool:
-		if v {
-			return nil, nil
-		} else {
-			return nil, nil
-		}
+		return nil, nil
 	case string:
 		return []string{v}, nil
 	case []string:
@@ -76,7 +75,7 @@ func toStrings(a any) ([]string, error) {
 		for i, arg := range v {
 			s, isString := arg.(string)
 			if !isString {
-				break
+				return nil, fmt.Errorf("not a string: %v", arg)
 			}
 			list[i] = s
 		}
@@ -97,7 +96,18 @@ func ReadFile(file string) (*Config, error) {
 	return config, nil
 }
 
+// IsJSON returns true if the provided data looks like a JSON object.
+func IsJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '{'
+}
+
+// Unmarshal parses a cloud-config document.
+// The document is either YAML that starts with Comment, or a JSON object.
 func Unmarshal(data []byte) (*Config, error) {
+	if IsJSON(data) {
+		return unmarshalJSON(data)
+	}
 	if !HasComment(data) {
 		return nil, fmt.Errorf("does not start with %s", Comment)
 	}
@@ -119,3 +129,21 @@ func Marshal(config *Config) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+func unmarshalJSON(data []byte) (*Config, error) {
+	var config Config
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// MarshalJSON encodes a config as indented JSON.
+func MarshalJSON(config *Config) ([]byte, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,382 @@
+package cloudconfig
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Severity of a Diagnostic
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a cloud-config document.
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	// Rule is the ID of the lint rule that produced the diagnostic, if any.
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+func (t Diagnostic) String() string {
+	s := fmt.Sprintf("%s:%d:%d: %s: %s", t.File, t.Line, t.Column, t.Severity, t.Message)
+	if t.Rule != "" {
+		s += " [" + t.Rule + "]"
+	}
+	return s
+}
+
+// HasErrors returns true if any of the diagnostics is an error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// HasWarnings returns true if any of the diagnostics is a warning, such as an unknown key.
+func HasWarnings(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
+type validator struct {
+	file        string
+	diagnostics []Diagnostic
+}
+
+// ValidateFile validates a cloud-config file.  See Validate.
+func ValidateFile(file string) ([]Diagnostic, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	v := &validator{file: file}
+	v.validate(data)
+	return v.diagnostics, nil
+}
+
+// Validate checks a YAML or JSON cloud-config document against the Config model.
+// It reports unknown keys as warnings, and wrong types, invalid permissions,
+// owners that are not user:group, and empty commands as errors.
+func Validate(data []byte) []Diagnostic {
+	v := &validator{}
+	v.validate(data)
+	return v.diagnostics
+}
+
+func (t *validator) addf(node *yaml3.Node, severity Severity, format string, args ...any) {
+	d := Diagnostic{File: t.file, Severity: severity, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		d.Line = node.Line
+		d.Column = node.Column
+	}
+	t.diagnostics = append(t.diagnostics, d)
+}
+
+func (t *validator) validate(data []byte) {
+	if !IsJSON(data) && !HasComment(data) {
+		t.diagnostics = append(t.diagnostics, Diagnostic{File: t.file, Line: 1, Column: 1,
+			Severity: SeverityError, Message: fmt.Sprintf("does not start with %s", Comment)})
+	}
+	var doc yaml3.Node
+	err := yaml3.Unmarshal(data, &doc)
+	if err != nil {
+		d := Diagnostic{File: t.file, Severity: SeverityError, Message: err.Error()}
+		if e, ok := err.(*yaml3.TypeError); ok && len(e.Errors) > 0 {
+			d.Message = strings.Join(e.Errors, "; ")
+		}
+		d.Line = yamlErrorLine(err.Error())
+		t.diagnostics = append(t.diagnostics, d)
+		return
+	}
+	if len(doc.Content) == 0 {
+		return
+	}
+	t.validateValue(doc.Content[0], reflect.TypeOf(Config{}), "")
+}
+
+// yamlErrorLine extracts the line number from a yaml error message, such as "yaml: line 3: ..."
+func yamlErrorLine(msg string) int {
+	_, rest, found := strings.Cut(msg, "line ")
+	if !found {
+		return 0
+	}
+	s, _, _ := strings.Cut(rest, ":")
+	line, _ := strconv.Atoi(s)
+	return line
+}
+
+func kindName(node *yaml3.Node) string {
+	switch node.Kind {
+	case yaml3.MappingNode:
+		return "mapping"
+	case yaml3.SequenceNode:
+		return "list"
+	case yaml3.ScalarNode:
+		// the kind in the model, as in scalarValue
+		switch scalarValue(node).(type) {
+		case nil:
+			return "null"
+		case bool:
+			return "bool"
+		case string:
+			return "str"
+		case int, int64, uint64:
+			return "int"
+		case float64:
+			return "float"
+		}
+		return strings.TrimPrefix(node.ShortTag(), "!!")
+	case yaml3.AliasNode:
+		return "alias"
+	}
+	return "node"
+}
+
+// yamlKey returns the yaml key of a struct field, or "" if the field is inline or ignored.
+func yamlKey(field reflect.StructField) string {
+	name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" || strings.Contains(options, "inline") {
+		return ""
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func (t *validator) validateValue(node *yaml3.Node, typ reflect.Type, key string) {
+	if node.Kind == yaml3.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml3.ScalarNode && node.ShortTag() == "!!null" {
+		return
+	}
+	if typ == reflect.TypeOf(Commands{}) {
+		t.validateCommands(node, key)
+		return
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		t.validateValue(node, typ.Elem(), key)
+	case reflect.Struct:
+		t.validateStruct(node, typ, key)
+	case reflect.Slice:
+		if node.Kind != yaml3.SequenceNode {
+			t.addf(node, SeverityError, "%s: expected list, found %s", key, kindName(node))
+			return
+		}
+		for _, item := range node.Content {
+			t.validateValue(item, typ.Elem(), key)
+		}
+	case reflect.String:
+		if node.Kind != yaml3.ScalarNode {
+			t.addf(node, SeverityError, "%s: expected string, found %s", key, kindName(node))
+		}
+	case reflect.Bool:
+		if _, isBool := scalarValue(node).(bool); !isBool {
+			t.addf(node, SeverityError, "%s: expected bool, found %s", key, kindName(node))
+		}
+	case reflect.Interface:
+		t.validateAny(node, key)
+	}
+}
+
+func (t *validator) validateStruct(node *yaml3.Node, typ reflect.Type, key string) {
+	if node.Kind != yaml3.MappingNode {
+		t.addf(node, SeverityError, "%s: expected mapping, found %s", key, kindName(node))
+		return
+	}
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := yamlKey(field)
+		if name != "" {
+			fields[name] = field
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		field, exists := fields[keyNode.Value]
+		if !exists {
+			t.addf(keyNode, SeverityWarning, "unknown key: %s", keyNode.Value)
+			continue
+		}
+		t.validateValue(valueNode, field.Type, keyNode.Value)
+		if valueNode.Kind != yaml3.ScalarNode {
+			continue
+		}
+		switch typ {
+		case reflect.TypeOf(File{}):
+			t.validateFileField(keyNode.Value, valueNode)
+		case reflect.TypeOf(User{}):
+			if keyNode.Value == "name" && valueNode.Value == "" {
+				t.addf(valueNode, SeverityError, "empty user name")
+			}
+		}
+	}
+}
+
+func (t *validator) validateFileField(key string, node *yaml3.Node) {
+	switch key {
+	case "permissions":
+		_, err := strconv.ParseInt(node.Value, 8, 32)
+		if err != nil {
+			t.addf(node, SeverityError, "invalid permissions (expected octal): %s", node.Value)
+		}
+	case "owner":
+		user, group, found := strings.Cut(node.Value, ":")
+		if !found || user == "" || group == "" || strings.Contains(group, ":") {
+			t.addf(node, SeverityError, "invalid owner (expected user:group): %s", node.Value)
+		}
+	case "path":
+		if node.Value == "" {
+			t.addf(node, SeverityError, "empty path")
+		}
+	}
+}
+
+func (t *validator) validateCommands(node *yaml3.Node, key string) {
+	if node.Kind != yaml3.SequenceNode {
+		t.addf(node, SeverityError, "%s: expected list, found %s", key, kindName(node))
+		return
+	}
+	for _, item := range node.Content {
+		switch item.Kind {
+		case yaml3.ScalarNode:
+			if strings.TrimSpace(item.Value) == "" {
+				t.addf(item, SeverityError, "%s: empty command", key)
+			}
+		case yaml3.SequenceNode:
+			if len(item.Content) == 0 {
+				t.addf(item, SeverityError, "%s: empty command", key)
+			}
+			for _, arg := range item.Content {
+				if arg.Kind != yaml3.ScalarNode {
+					t.addf(arg, SeverityError, "%s: command argument must be a scalar, found %s", key, kindName(arg))
+				}
+			}
+		case yaml3.MappingNode:
+			t.validateCommandMap(item, key)
+		default:
+			t.addf(item, SeverityError, "%s: command must be a string, a list, or a map, found %s", key, kindName(item))
+		}
+	}
+}
+
+// validateCommandMap validates a command in the map form: {cmd, user, dir, env, stdin}
+func (t *validator) validateCommandMap(node *yaml3.Node, key string) {
+	hasCmd := false
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		switch k.Value {
+		case CommandKeyCmd:
+			hasCmd = true
+			switch v.Kind {
+			case yaml3.ScalarNode:
+				if strings.TrimSpace(v.Value) == "" {
+					t.addf(v, SeverityError, "%s: empty command", key)
+				}
+			case yaml3.SequenceNode:
+				if len(v.Content) == 0 {
+					t.addf(v, SeverityError, "%s: empty command", key)
+				}
+				for _, arg := range v.Content {
+					if arg.Kind != yaml3.ScalarNode {
+						t.addf(arg, SeverityError, "%s: command argument must be a scalar, found %s", key, kindName(arg))
+					}
+				}
+			default:
+				t.addf(v, SeverityError, "%s: cmd must be a string or a list, found %s", key, kindName(v))
+			}
+		case CommandKeyUser, CommandKeyDir, CommandKeyStdin:
+			if v.Kind != yaml3.ScalarNode {
+				t.addf(v, SeverityError, "%s: %s: expected string, found %s", key, k.Value, kindName(v))
+			}
+		case CommandKeyEnv:
+			if v.Kind != yaml3.MappingNode {
+				t.addf(v, SeverityError, "%s: env: expected map, found %s", key, kindName(v))
+				continue
+			}
+			for j := 1; j < len(v.Content); j += 2 {
+				if v.Content[j].Kind != yaml3.ScalarNode {
+					t.addf(v.Content[j], SeverityError, "%s: env: expected scalar, found %s", key, kindName(v.Content[j]))
+				}
+			}
+		default:
+			t.addf(k, SeverityError, "%s: unknown command key: %s", key, k.Value)
+		}
+	}
+	if !hasCmd {
+		t.addf(node, SeverityError, "%s: missing cmd", key)
+	}
+}
+
+// scalarValue returns the value of a scalar node as yaml.v2 decodes it into the model,
+// following YAML 1.1, where yes, no, on and off are bools.
+// It returns nil if node is not a scalar.
+func scalarValue(node *yaml3.Node) any {
+	if node.Kind != yaml3.ScalarNode {
+		return nil
+	}
+	if node.Style&(yaml3.DoubleQuotedStyle|yaml3.SingleQuotedStyle|yaml3.LiteralStyle|yaml3.FoldedStyle) != 0 ||
+		node.Style&yaml3.TaggedStyle != 0 && node.ShortTag() == "!!str" {
+		return node.Value
+	}
+	var v any
+	if yaml.Unmarshal([]byte(node.Value), &v) != nil {
+		return node.Value
+	}
+	return v
+}
+
+// validateAny validates the fields that have type any
+func (t *validator) validateAny(node *yaml3.Node, key string) {
+	switch key {
+	case "sudo":
+		switch node.Kind {
+		case yaml3.ScalarNode:
+			switch scalarValue(node).(type) {
+			case nil, bool, string:
+			default:
+				t.addf(node, SeverityError, "sudo: expected bool, string or list, found %s", kindName(node))
+			}
+		case yaml3.SequenceNode:
+			for _, item := range node.Content {
+				if _, isString := scalarValue(item).(string); !isString {
+					t.addf(item, SeverityError, "sudo: expected string, found %s", kindName(item))
+				}
+			}
+		default:
+			t.addf(node, SeverityError, "sudo: expected bool, string or list, found %s", kindName(node))
+		}
+	case "merge_how", "merge_type":
+		var v any
+		err := node.Decode(&v)
+		if err == nil {
+			_, err = ParseMergeHow(NormalizeYaml(v))
+		}
+		if err != nil {
+			t.addf(node, SeverityError, "%s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package cloudconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	data := []byte(`#cloud-config
+write_file:
+- path: /a
+write_files:
+- path: /tmp/a
+  permissions: '0789'
+  owner: root
+  append: yes please
+users:
+- name: a
+  ssh_authorised_keys: []
+  sudo: {a: b}
+runcmd:
+- ""
+- []
+- [echo, a]
+`)
+	diagnostics := Validate(data)
+	expected := []string{
+		":2:1: warning: unknown key: write_file",
+		":6:16: error: invalid permissions",
+		":7:10: error: invalid owner",
+		":8:11: error: append: expected bool",
+		":11:3: warning: unknown key: ssh_authorised_keys",
+		":12:9: error: sudo:",
+		":14:3: error: runcmd: empty command",
+		":15:3: error: runcmd: empty command",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("%v", diagnostics)
+	}
+	for i, d := range diagnostics {
+		if !strings.HasPrefix(d.String(), expected[i]) {
+			t.Errorf("%s, expected %s", d.String(), expected[i])
+		}
+	}
+	if !HasErrors(diagnostics) {
+		t.Fail()
+	}
+	if d := Validate([]byte("#cloud-config\npackages: [a]\n")); len(d) != 0 {
+		t.Fatalf("%v", d)
+	}
+}
+
+func TestValidateCommandMap(t *testing.T) {
+	data := []byte(`#cloud-config
+runcmd:
+- cmd: [make, install]
+  user: deploy
+  env: {PREFIX: /usr/local, N: 1}
+- {user: deploy, shell: bash}
+`)
+	diagnostics := Validate(data)
+	expected := []string{
+		":6:18: error: runcmd: unknown command key: shell",
+		":6:3: error: runcmd: missing cmd",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("%v", diagnostics)
+	}
+	for i, d := range diagnostics {
+		if !strings.HasPrefix(d.String(), expected[i]) {
+			t.Errorf("%s, expected %s", d.String(), expected[i])
+		}
+	}
+}
+
+func TestValidateYaml11(t *testing.T) {
+	data := []byte(`#cloud-config
+write_files:
+- path: /tmp/a
+  append: yes
+  defer: off
+users:
+- name: a
+  sudo: [ALL, yes, 1]
+- name: b
+  sudo: 1
+`)
+	diagnostics := Validate(data)
+	expected := []string{
+		":8:15: error: sudo: expected string, found bool",
+		":8:20: error: sudo: expected string, found int",
+		":10:9: error: sudo: expected bool, string or list, found int",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("%v", diagnostics)
+	}
+	for i, d := range diagnostics {
+		if !strings.HasPrefix(d.String(), expected[i]) {
+			t.Fatalf("%s", d.String())
+		}
+	}
+	c, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Files[0].Append || c.Files[0].Defer {
+		t.Fatalf("%v", c.Files[0])
+	}
+}